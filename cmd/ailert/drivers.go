@@ -0,0 +1,9 @@
+package main
+
+// database/sql drivers available to type: sql sources (DuckDB is registered by internal/duckdb).
+import (
+	_ "github.com/ClickHouse/clickhouse-go/v2"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
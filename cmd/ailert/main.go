@@ -2,20 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ailert/ailert/internal/alertmanager"
 	"github.com/ailert/ailert/internal/changes"
+	"github.com/ailert/ailert/internal/checkpoint"
 	"github.com/ailert/ailert/internal/config"
 	"github.com/ailert/ailert/internal/duckdb"
 	"github.com/ailert/ailert/internal/engine"
+	"github.com/ailert/ailert/internal/filter"
 	"github.com/ailert/ailert/internal/pattern"
 	"github.com/ailert/ailert/internal/snapshot"
 	"github.com/ailert/ailert/internal/metrics"
@@ -40,6 +44,18 @@ func getStore(cfg *config.Config) (store.PatternStore, *duckdb.DB, error) {
 	return st, nil, nil
 }
 
+// getCheckpoints returns where file sources keep their read offsets: the checkpoints table when
+// DuckDB is in use, else checkpoints.json next to store_path. Returns nil when nothing is persisted.
+func getCheckpoints(cfg *config.Config, db *duckdb.DB) checkpoint.Store {
+	if db != nil {
+		return duckdb.NewCheckpointStore(db)
+	}
+	if cfg.StorePath != "" {
+		return checkpoint.New(filepath.Join(filepath.Dir(cfg.StorePath), "checkpoints.json"))
+	}
+	return nil
+}
+
 func main() {
 	if len(os.Args) < 2 {
 		printUsage()
@@ -59,6 +75,8 @@ func main() {
 		err = cmdSuggestRules(args)
 	case "apply-rule":
 		err = cmdApplyRule(args)
+	case "migrate":
+		err = cmdMigrate(args)
 	default:
 		printUsage()
 		os.Exit(1)
@@ -78,6 +96,7 @@ Commands:
   detect-changes  Compare current store to last snapshot, print diff
   suggest-rules   From last run or snapshot, suggest suppress/alert rules (heuristic)
   apply-rule      Apply a rule: suppress <hash> or alert <hash>
+  migrate         Re-hash stored patterns after a hashing or masking change; rewrite suppressions and snapshots
 
 Use -h with a command for details.
 `)
@@ -88,12 +107,17 @@ func cmdRun(args []string) error {
 	configPath := fs.String("config", "config.yaml", "Config YAML")
 	saveSnapshot := fs.String("save-snapshot", "", "Save snapshot to this dir after run (for detect-changes). With duckdb_path, snapshot is stored in DuckDB instead.")
 	metricsAddr := fs.String("metrics-addr", "", "If set, serve Prometheus metrics on this address (e.g. :9090)")
+	rescan := fs.Bool("rescan", false, "Ignore saved file checkpoints and read sources from the beginning")
+	sourceArg := fs.String("source", "", `Read only this source instead of the config's: "-" for stdin or a log file path (also as an argument: ailert run -); the config file is then optional`)
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
-	cfg, err := config.Load(*configPath)
+	if *sourceArg == "" && fs.NArg() > 0 {
+		*sourceArg = fs.Arg(0)
+	}
+	cfg, err := loadRunConfig(fs, *configPath, *sourceArg)
 	if err != nil {
-		return fmt.Errorf("load config: %w", err)
+		return err
 	}
 	st, db, err := getStore(cfg)
 	if err != nil {
@@ -105,7 +129,19 @@ func cmdRun(args []string) error {
 	if err := st.Load(); err != nil {
 		return fmt.Errorf("load store: %w", err)
 	}
-	eng := engine.New(st)
+	cps := getCheckpoints(cfg, db)
+	if cps != nil {
+		if err := cps.Load(); err != nil {
+			return fmt.Errorf("load checkpoints: %w", err)
+		}
+	}
+	if n := staleHashes(st.ListSeen(), pattern.MaskFingerprint(cfg.Patterns.Masks)); n > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d stored patterns were hashed with an older hash scheme or other mask rules; run ailert migrate to keep their history and suppressions\n", n)
+	}
+	eng, err := engine.NewWithOptions(st, cfg.Patterns)
+	if err != nil {
+		return fmt.Errorf("patterns: %w", err)
+	}
 	var amClient *alertmanager.Client
 	if cfg.AlertmanagerURL != "" {
 		amClient = alertmanager.NewClient(cfg.AlertmanagerURL)
@@ -128,16 +164,27 @@ func cmdRun(args []string) error {
 		}
 	}
 
+	if *metricsAddr != "" {
+		metrics.Serve(*metricsAddr) // listens in the background while sources run
+	}
+	var wms *duckdb.WatermarkStore
+	if db != nil {
+		wms = duckdb.NewWatermarkStore(db)
+	}
 	var wg sync.WaitGroup
 	for _, spec := range cfg.Sources {
-		src := sourceFromSpec(spec, db)
+		src := sourceFromSpec(spec, db, cps, wms, *rescan)
 		if src == nil {
 			return fmt.Errorf("unknown source type %q", spec.Type)
 		}
+		stage, err := filter.New(spec.Rules)
+		if err != nil {
+			return fmt.Errorf("source %s: %w", src.ID(), err)
+		}
 		wg.Add(1)
 		go func(s source.Source) {
 			defer wg.Done()
-			runSource(ctx, eng, s, amClient, appendRecord)
+			runSource(ctx, eng, s, stage, amClient, appendRecord)
 		}(src)
 	}
 	go func() {
@@ -146,11 +193,19 @@ func cmdRun(args []string) error {
 	}()
 
 	<-ctx.Done()
+	wg.Wait() // let in-flight records finish so store and checkpoints agree
 	if err := st.Save(); err != nil {
 		return fmt.Errorf("save store: %w", err)
 	}
-	if *metricsAddr != "" {
-		metrics.Serve(*metricsAddr)
+	if cps != nil {
+		if err := cps.Save(); err != nil {
+			return fmt.Errorf("save checkpoints: %w", err)
+		}
+	}
+	if wms != nil {
+		if err := wms.Save(); err != nil {
+			return fmt.Errorf("save watermarks: %w", err)
+		}
 	}
 	list := st.ListSeen()
 	ents := make([]snapshot.PatternEnt, len(list))
@@ -174,25 +229,75 @@ func cmdRun(args []string) error {
 	return nil
 }
 
-func sourceFromSpec(spec config.SourceSpec, db *duckdb.DB) source.Source {
+// loadRunConfig loads the run config. With a -source argument the config file is optional
+// (unless -config was given) and its sources are replaced by that one source.
+func loadRunConfig(fs *flag.FlagSet, path, sourceArg string) (*config.Config, error) {
+	if sourceArg == "" {
+		cfg, err := config.Load(path)
+		if err != nil {
+			return nil, fmt.Errorf("load config: %w", err)
+		}
+		return cfg, nil
+	}
+	explicit := false
+	fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "config" })
+	cfg, err := config.Load(path)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err) && !explicit:
+		cfg = &config.Config{}
+	default:
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	spec := config.SourceSpec{ID: "stdin", Type: "stdin"}
+	if sourceArg != "-" {
+		spec = config.SourceSpec{ID: sourceArg, Type: "file", Path: sourceArg}
+	}
+	cfg.Sources = []config.SourceSpec{spec}
+	return cfg, nil
+}
+
+func sourceFromSpec(spec config.SourceSpec, db *duckdb.DB, cps checkpoint.Store, wms *duckdb.WatermarkStore, rescan bool) source.Source {
 	switch spec.Type {
 	case "file":
-		return &source.FileSource{Path: spec.Path, SourceID: spec.ID}
+		return &source.FileSource{Path: spec.Path, SourceID: spec.ID, Tail: spec.Tail, Checkpoints: cps, Rescan: rescan, Format: spec.Format, Multiline: spec.Multiline, Container: spec.Container}
 	case "prometheus", "metrics":
-		return &source.PrometheusSource{URL: spec.URL, SourceID: spec.ID}
+		return &source.PrometheusSource{URL: spec.URL, SourceID: spec.ID, Interval: spec.Interval, RateJump: spec.RateJump}
 	case "http":
-		return &source.HTTPSource{URL: spec.URL, SourceID: spec.ID}
+		return &source.HTTPSource{URL: spec.URL, SourceID: spec.ID, Format: spec.Format, Multiline: spec.Multiline, Interval: spec.Interval}
+	case "syslog":
+		return &source.SyslogSource{Addr: spec.Listen, Protocol: spec.Protocol, SourceID: spec.ID}
+	case "stdin":
+		return &source.StdinSource{SourceID: spec.ID, Format: spec.Format, Multiline: spec.Multiline}
+	case "journald":
+		return &source.JournaldSource{Path: spec.Path, SourceID: spec.ID}
+	case "http_push":
+		return &source.HTTPPushSource{Addr: spec.Listen, SourceID: spec.ID, Format: spec.Format}
+	case "otlp":
+		return &source.OTLPSource{Addr: spec.Listen, SourceID: spec.ID, Format: spec.Format}
+	case "loki":
+		return &source.LokiSource{URL: spec.URL, Query: spec.Query, SourceID: spec.ID, Since: spec.Since, Limit: spec.Limit, Interval: spec.Interval, Format: spec.Format}
+	case "kafka":
+		return &source.KafkaSource{Brokers: spec.Brokers, Topics: spec.Topics, Group: spec.Group, SourceID: spec.ID, Format: spec.Format}
+	case "sql":
+		src := &source.SQLSource{Driver: spec.Driver, DSN: spec.DSN, Query: spec.Query, SourceID: spec.ID, Format: spec.Format,
+			Incremental: spec.Incremental, Cursor: spec.Cursor, Interval: spec.Interval}
+		if wms != nil {
+			src.Watermarks = wms
+		}
+		return src
 	case "duckdb":
 		if db == nil {
 			return nil // duckdb source requires duckdb_path in config
 		}
-		return &source.DuckDBSource{DB: db.SQL(), Query: spec.Query, SourceID: spec.ID}
+		return &source.DuckDBSource{DB: db.SQL(), Query: spec.Query, SourceID: spec.ID,
+			Incremental: spec.Incremental, Cursor: spec.Cursor, Interval: spec.Interval, Watermarks: wms}
 	default:
 		return nil
 	}
 }
 
-func runSource(ctx context.Context, eng *engine.Engine, src source.Source, amClient *alertmanager.Client, appendRecord func(*types.Record)) {
+func runSource(ctx context.Context, eng *engine.Engine, src source.Source, stage *filter.Stage, amClient *alertmanager.Client, appendRecord func(*types.Record)) {
 	recCh, errCh := src.Stream(ctx)
 	for {
 		select {
@@ -200,6 +305,7 @@ func runSource(ctx context.Context, eng *engine.Engine, src source.Source, amCli
 			return
 		case err, ok := <-errCh:
 			if ok && err != nil {
+				metrics.SourceError(src.ID())
 				fmt.Fprintf(os.Stderr, "source %s: %v\n", src.ID(), err)
 			}
 			return
@@ -207,11 +313,24 @@ func runSource(ctx context.Context, eng *engine.Engine, src source.Source, amCli
 			if !ok {
 				return
 			}
+			if !stage.Apply(&rec) {
+				if rec.Ack != nil {
+					rec.Ack()
+				}
+				metrics.RecordsDropped.Add(1)
+				continue
+			}
 			if appendRecord != nil {
 				appendRecord(&rec)
 			}
 			res := eng.Process(&rec)
+			if rec.Ack != nil {
+				rec.Ack()
+			}
 			metrics.RecordsProcessed.Add(1)
+			for _, from := range res.Merged {
+				fmt.Printf("[%s] merged %s into %s %s\n", res.Level.String(), from, res.Hash, truncate(res.Template, 60))
+			}
 			if res.Suppressed {
 				metrics.PatternsSuppressed.Add(1)
 				continue
@@ -225,7 +344,11 @@ func runSource(ctx context.Context, eng *engine.Engine, src source.Source, amCli
 			if res.IsNew {
 				status = "new"
 			}
-			fmt.Printf("[%s] %s %s (count=%d) %s\n", res.Level.String(), status, res.Hash, res.Count, truncate(res.Sample, 60))
+			line := truncate(res.Template, 60)
+			if len(res.Params) > 0 {
+				line += fmt.Sprintf(" %q", res.Params)
+			}
+			fmt.Printf("[%s] %s %s (count=%d) %s\n", res.Level.String(), status, res.Hash, res.Count, line)
 			if amClient != nil {
 				emitAlert(amClient, &rec, &res)
 			}
@@ -249,6 +372,14 @@ func emitAlert(client *alertmanager.Client, rec *types.Record, res *engine.Resul
 		StartsAt: now,
 		EndsAt:   time.Time{},
 	}
+	if res.Template != "" {
+		a.Annotations["summary"] = truncate(res.Template, 200)
+		a.Annotations["template"] = res.Template
+	}
+	if len(res.Params) > 0 {
+		b, _ := json.Marshal(res.Params)
+		a.Annotations["params"] = string(b)
+	}
 	if err := client.PostAlerts([]alertmanager.Alert{a}); err != nil {
 		fmt.Fprintf(os.Stderr, "alertmanager: %v\n", err)
 	} else {
@@ -269,15 +400,19 @@ func cmdSuppress(args []string) error {
 	if *hash == "" && *patternLine == "" {
 		return fmt.Errorf("suppress: provide -hash or -pattern")
 	}
-	h := *hash
-	if h == "" {
-		p := pattern.New(*patternLine)
-		h = p.Hash()
-	}
 	cfg, err := config.Load(*configPath)
 	if err != nil {
 		return err
 	}
+	h := *hash
+	if h == "" {
+		// Hash the line the way run does, with the configured mask rules.
+		m, err := pattern.NewMasker(cfg.Patterns.Masks)
+		if err != nil {
+			return fmt.Errorf("patterns: %w", err)
+		}
+		h = pattern.NewMasked(*patternLine, m).Hash()
+	}
 	st, db, err := getStore(cfg)
 	if err != nil {
 		return err
@@ -288,6 +423,7 @@ func cmdSuppress(args []string) error {
 	if err := st.Load(); err != nil {
 		return err
 	}
+	h = st.Resolve(h)
 	st.Suppress(h, *reason)
 	if err := st.Save(); err != nil {
 		return err
@@ -455,6 +591,7 @@ func cmdApplyRule(args []string) error {
 	if err := st.Load(); err != nil {
 		return err
 	}
+	hash = st.Resolve(hash)
 	switch action {
 	case "suppress":
 		st.Suppress(hash, *reason)
@@ -505,6 +642,168 @@ func cmdApplyRule(args []string) error {
 	return nil
 }
 
+// staleHashes counts patterns stored with a hashing scheme other than the current one, or
+// hashed with mask rules other than those with fingerprint masks.
+func staleHashes(list []store.PatternInfo, masks string) int {
+	n := 0
+	for _, p := range list {
+		v := p.Version
+		if v == 0 {
+			v = 1 // stored before versions were kept
+		}
+		if v != pattern.HashVersion || p.Masks != masks {
+			n++
+		}
+	}
+	return n
+}
+
+func cmdMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "Config YAML")
+	snapshotDir := fs.String("snapshot-dir", "", "Directory with snapshot_latest.json to rewrite (default: snapshot_dir from config; not used with DuckDB)")
+	dryRun := fs.Bool("dry-run", false, "Only print the hashes that would change")
+	createSilence := fs.Bool("create-silence", false, "Create Alertmanager silences for suppressed patterns whose hash changed (requires alertmanager_url)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	masker, err := pattern.NewMasker(cfg.Patterns.Masks)
+	if err != nil {
+		return fmt.Errorf("patterns: %w", err)
+	}
+	st, db, err := getStore(cfg)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		defer db.Close()
+	}
+	if err := st.Load(); err != nil {
+		return err
+	}
+	// A pattern's ID is the hash of its sample (the first line seen), so re-hashing the
+	// sample gives the ID the current scheme and mask rules assign.
+	type key struct {
+		level types.Level
+		hash  string
+	}
+	renamed := make(map[key]string)
+	var renames []store.Rename
+	skipped := 0
+	stored := make(map[string]bool)
+	for _, p := range st.ListSeen() {
+		stored[p.Hash] = true
+		if p.Sample == "" {
+			skipped++
+			continue
+		}
+		if h := pattern.NewMasked(p.Sample, masker).Hash(); h != p.Hash {
+			renames = append(renames, store.Rename{Level: p.Level, From: p.Hash, To: h})
+			renamed[key{p.Level, p.Hash}] = h
+		}
+	}
+	for _, r := range renames {
+		fmt.Printf("  %s %s -> %s\n", r.Level.String(), r.From, r.To)
+	}
+	fmt.Printf("%d patterns re-hashed to scheme %d", len(renames), pattern.HashVersion)
+	if skipped > 0 {
+		fmt.Printf(", %d without a sample left unchanged", skipped)
+	}
+	fmt.Println()
+	// A suppression is re-hashed with its pattern; without one there is no sample to re-hash.
+	var orphans []string
+	for h := range st.ListSuppressed() {
+		if !stored[h] && !stored[st.Resolve(h)] {
+			orphans = append(orphans, h)
+		}
+	}
+	if len(orphans) > 0 {
+		sort.Strings(orphans)
+		fmt.Printf("warning: %d suppressions have no stored pattern to re-hash and may stop matching; suppress them again with -pattern if so:\n", len(orphans))
+		for _, h := range orphans {
+			fmt.Printf("  %s\n", h)
+		}
+	}
+	if *dryRun {
+		return nil
+	}
+	var silenced []store.Rename
+	for _, r := range renames {
+		if st.IsSuppressed(r.From) {
+			silenced = append(silenced, r)
+		}
+	}
+	scheme := store.Scheme{Version: pattern.HashVersion, Masks: pattern.MaskFingerprint(cfg.Patterns.Masks)}
+	if err := st.Rehash(renames, scheme); err != nil {
+		return fmt.Errorf("migrate store: %w", err)
+	}
+	if err := st.Save(); err != nil {
+		return err
+	}
+
+	// Snapshot entries not in the store any more are re-hashed from their own sample.
+	rehash := func(p snapshot.PatternEnt) string {
+		if h, ok := renamed[key{p.Level, p.Hash}]; ok {
+			return h
+		}
+		if p.Sample != "" {
+			return pattern.NewMasked(p.Sample, masker).Hash()
+		}
+		return p.Hash
+	}
+	dir := *snapshotDir
+	if dir == "" {
+		dir = cfg.SnapshotDir
+	}
+	if db != nil {
+		n, err := db.RehashSnapshots(rehash)
+		if err != nil {
+			return fmt.Errorf("rewrite snapshots: %w", err)
+		}
+		fmt.Printf("%d snapshots rewritten\n", n)
+	} else if dir != "" {
+		path := filepath.Join(dir, "snapshot_latest.json")
+		snap, err := snapshot.Load(path)
+		if err != nil {
+			return err
+		}
+		if snap != nil && snap.Rehash(rehash) {
+			if err := snap.Write(path); err != nil {
+				return fmt.Errorf("rewrite snapshot: %w", err)
+			}
+			fmt.Println("Snapshot rewritten:", path)
+		}
+	}
+
+	if len(silenced) == 0 {
+		return nil
+	}
+	if !*createSilence || cfg.AlertmanagerURL == "" {
+		fmt.Printf("%d suppressed patterns changed hash; Alertmanager silences on pattern_hash need updating (rerun with -create-silence)\n", len(silenced))
+		return nil
+	}
+	client := alertmanager.NewClient(cfg.AlertmanagerURL)
+	for _, r := range silenced {
+		sil := alertmanager.Silence{
+			Matchers:  []alertmanager.Matcher{{Name: "pattern_hash", Value: r.To, IsRegex: false}},
+			StartsAt:  time.Now(),
+			EndsAt:    time.Now().Add(8760 * time.Hour), // 1 year
+			CreatedBy: "ailert",
+			Comment:   "migrated from pattern " + r.From,
+		}
+		id, err := client.PostSilence(sil)
+		if err != nil {
+			return fmt.Errorf("create Alertmanager silence: %w", err)
+		}
+		fmt.Println("Alertmanager silence created:", id, "for", r.To)
+	}
+	return nil
+}
+
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
@@ -524,6 +823,10 @@ func printSummary(st store.PatternStore) {
 	}
 	fmt.Printf("Total patterns: %d, total messages: %d\n", len(list), total)
 	for _, p := range list {
-		fmt.Printf("  %s %s count=%d %s\n", p.Level.String(), p.Hash, p.Count, truncate(p.Sample, 50))
+		text := p.Template
+		if text == "" {
+			text = p.Sample
+		}
+		fmt.Printf("  %s %s count=%d %s\n", p.Level.String(), p.Hash, p.Count, truncate(text, 50))
 	}
 }
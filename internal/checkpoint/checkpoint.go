@@ -0,0 +1,131 @@
+package checkpoint
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FingerprintSize is how many leading bytes of a file identify it across restarts.
+const FingerprintSize = 1024
+
+// Checkpoint is the resume position of one file read by a source.
+type Checkpoint struct {
+	Offset      int64  `json:"offset"`      // byte offset just past the last processed line
+	Inode       uint64 `json:"inode"`       // file identity (0 where the platform has none)
+	Fingerprint string `json:"fingerprint"` // md5 of the first min(FingerprintSize, Offset) bytes
+}
+
+// Store keeps checkpoints keyed by (source ID, file path).
+// Implementations: JSON file (JSONStore) or DuckDB-backed store.
+type Store interface {
+	Get(sourceID, path string) (Checkpoint, bool)
+	Set(sourceID, path string, cp Checkpoint)
+	List(sourceID string) map[string]Checkpoint // by path
+	Load() error
+	Save() error
+}
+
+// Fingerprint returns the fingerprint of a file's leading bytes (callers pass at most FingerprintSize).
+func Fingerprint(head []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(head))
+}
+
+// JSONStore holds checkpoints in memory with optional JSON persistence. Safe for concurrent use.
+type JSONStore struct {
+	mu          sync.RWMutex
+	cps         map[key]Checkpoint
+	persistPath string
+}
+
+type key struct {
+	SourceID string
+	Path     string
+}
+
+// New returns an in-memory checkpoint store. If persistPath is non-empty, Load/Save will use it.
+func New(persistPath string) *JSONStore {
+	return &JSONStore{cps: make(map[key]Checkpoint), persistPath: persistPath}
+}
+
+// Get returns the checkpoint for (sourceID, path), if any.
+func (s *JSONStore) Get(sourceID, path string) (Checkpoint, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp, ok := s.cps[key{SourceID: sourceID, Path: path}]
+	return cp, ok
+}
+
+// Set records the checkpoint for (sourceID, path).
+func (s *JSONStore) Set(sourceID, path string, cp Checkpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cps[key{SourceID: sourceID, Path: path}] = cp
+}
+
+// List returns the checkpoints of sourceID by path.
+func (s *JSONStore) List(sourceID string) map[string]Checkpoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]Checkpoint)
+	for k, cp := range s.cps {
+		if k.SourceID == sourceID {
+			out[k.Path] = cp
+		}
+	}
+	return out
+}
+
+type persistEnt struct {
+	SourceID string `json:"source_id"`
+	Path     string `json:"path"`
+	Checkpoint
+}
+
+// Load restores checkpoints from persistPath if set and the file exists.
+func (s *JSONStore) Load() error {
+	if s.persistPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.persistPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var ents []persistEnt
+	if err := json.Unmarshal(data, &ents); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range ents {
+		s.cps[key{SourceID: e.SourceID, Path: e.Path}] = e.Checkpoint
+	}
+	return nil
+}
+
+// Save writes checkpoints to persistPath if set.
+func (s *JSONStore) Save() error {
+	if s.persistPath == "" {
+		return nil
+	}
+	s.mu.RLock()
+	ents := make([]persistEnt, 0, len(s.cps))
+	for k, cp := range s.cps {
+		ents = append(ents, persistEnt{SourceID: k.SourceID, Path: k.Path, Checkpoint: cp})
+	}
+	s.mu.RUnlock()
+	data, err := json.MarshalIndent(ents, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.persistPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.persistPath, data, 0644)
+}
@@ -0,0 +1,49 @@
+package checkpoint
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONStoreGetSet(t *testing.T) {
+	s := New("")
+	if _, ok := s.Get("app", "/var/log/app.log"); ok {
+		t.Fatal("empty store should have no checkpoint")
+	}
+	cp := Checkpoint{Offset: 42, Inode: 7, Fingerprint: Fingerprint([]byte("hello"))}
+	s.Set("app", "/var/log/app.log", cp)
+	got, ok := s.Get("app", "/var/log/app.log")
+	if !ok || got != cp {
+		t.Errorf("Get = %+v, %v; want %+v", got, ok, cp)
+	}
+	if _, ok := s.Get("other", "/var/log/app.log"); ok {
+		t.Error("checkpoints are per source ID")
+	}
+	if l := s.List("app"); len(l) != 1 || l["/var/log/app.log"] != cp || len(s.List("other")) != 0 {
+		t.Errorf("List = %+v", l)
+	}
+}
+
+func TestJSONStorePersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	s := New(path)
+	cp := Checkpoint{Offset: 100, Inode: 3, Fingerprint: "abc"}
+	s.Set("app", "/a.log", cp)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s2 := New(path)
+	if err := s2.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := s2.Get("app", "/a.log"); !ok || got != cp {
+		t.Errorf("after reload Get = %+v, %v", got, ok)
+	}
+}
+
+func TestJSONStoreLoadMissing(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "missing.json"))
+	if err := s.Load(); err != nil {
+		t.Errorf("Load(missing) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package checkpoint
+
+import "os"
+
+// Inode returns 0: file identity falls back to the content fingerprint on this platform.
+func Inode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -2,8 +2,13 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/ailert/ailert/internal/engine"
+	"github.com/ailert/ailert/internal/filter"
+	"github.com/ailert/ailert/internal/source"
 )
 
 // Config is the root configuration for ailert.
@@ -13,15 +18,53 @@ type Config struct {
 	AlertmanagerURL string       `yaml:"alertmanager_url"`  // optional; emit alerts / create silences
 	SnapshotDir     string       `yaml:"snapshot_dir"`     // optional; directory for file snapshots (used only when DuckDBPath is empty)
 	Sources         []SourceSpec `yaml:"sources"`
+	// Patterns tunes how lines are grouped into patterns (similarity threshold, parse tree depth).
+	Patterns engine.Options `yaml:"patterns"`
 }
 
 // SourceSpec describes one data source (file, prometheus, duckdb, etc.).
 type SourceSpec struct {
-	ID    string `yaml:"id"`
-	Type  string `yaml:"type"`  // "file", "prometheus", "http", "duckdb", ...
-	Path  string `yaml:"path"` // for type=file; for type=duckdb optional DB path (else use config duckdb_path)
-	URL   string `yaml:"url"`   // for type=prometheus, http
-	Query string `yaml:"query"` // for type=duckdb optional SQL query (default: SELECT from records)
+	ID       string `yaml:"id"`
+	Type     string `yaml:"type"`     // "file", "prometheus", "http", "duckdb", "syslog", "http_push", "loki", "journald", "stdin", "kafka", "otlp", ...
+	Path     string `yaml:"path"`     // for type=file: a file, glob or directory; for type=journald a file or "-" (stdin); for type=duckdb optional DB path (else use config duckdb_path)
+	URL      string `yaml:"url"`      // for type=prometheus, http; for type=loki the Loki base URL
+	Query    string `yaml:"query"`    // for type=duckdb optional SQL query (default: SELECT from records); for type=sql the SQL query; for type=loki the LogQL query
+	Driver   string `yaml:"driver"`   // for type=sql: database/sql driver (postgres, mysql, sqlite, clickhouse, duckdb)
+	DSN      string `yaml:"dsn"`      // for type=sql: data source name passed to the driver
+	Tail     bool   `yaml:"tail"`     // for type=file: follow the file (tail -F) instead of reading it once
+	Listen   string `yaml:"listen"`   // for type=syslog, http_push, otlp: listen address (e.g. ":5514")
+	Protocol string `yaml:"protocol"` // for type=syslog: "udp", "tcp" or empty for both
+	// Brokers, Topics and Group configure type=kafka: seed brokers, topics to consume and the
+	// consumer group whose offsets are committed once records are processed.
+	Brokers []string `yaml:"brokers"`
+	Topics  []string `yaml:"topics"`
+	Group   string   `yaml:"group"`
+	// Interval re-fetches type=http and prometheus, and polls type=loki and incremental duckdb and sql
+	// for new entries, on this schedule for the life of the run (failed fetches back off); 0 fetches
+	// once. type=sql rejects it without incremental.
+	Interval time.Duration `yaml:"interval"`
+	// Since and Limit tune type=loki: how far back the first query reaches (default 1h) and
+	// entries per query_range page (default 1000).
+	Since time.Duration `yaml:"since"`
+	Limit int           `yaml:"limit"`
+	// RateJump is the factor by which a counter's rate must grow between scrapes for
+	// type=prometheus to emit a rate jump event (default 3).
+	RateJump float64 `yaml:"rate_jump"`
+	// Incremental makes type=duckdb and sql read only rows past a watermark on Cursor (a result
+	// column, default "timestamp"), kept per source id in the DuckDB database (in memory only
+	// for type=sql without duckdb_path).
+	Incremental bool   `yaml:"incremental"`
+	Cursor      string `yaml:"cursor"`
+	// Format decodes structured lines for type=file, stdin, http, http_push, otlp, loki and kafka (type: json|logfmt|regex plus field mapping);
+	// for type=sql its field names map result columns.
+	Format source.FormatMapping `yaml:"format"`
+	// Multiline joins continuation lines (stack traces) into one record for type=file, http and stdin.
+	Multiline *source.MultilineRule `yaml:"multiline"`
+	// Container unwraps Kubernetes container runtime logs for type=file: "cri", "docker" or "auto".
+	Container string `yaml:"container"`
+	// Rules (include, exclude, labels, rename_labels, copy_labels) filter and label every
+	// record of the source before it reaches the engine.
+	filter.Rules `yaml:",inline"`
 }
 
 // Load reads config from a YAML file.
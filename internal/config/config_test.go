@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -48,6 +49,114 @@ sources:
 	}
 }
 
+func TestLoadFormat(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+sources:
+  - id: api
+    type: file
+    path: /var/log/api.json
+    format:
+      type: json
+      level_field: severity
+      message_field: text
+      label_fields:
+        svc: service
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := cfg.Sources[0].Format
+	if f.Type != "json" || f.LevelField != "severity" || f.MessageField != "text" || f.LabelFields["svc"] != "service" {
+		t.Errorf("Format = %+v", f)
+	}
+}
+
+func TestLoadLoki(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+sources:
+  - id: loki
+    type: loki
+    url: http://localhost:3100
+    query: '{app="api"} |= "error"'
+    since: 30m
+    limit: 500
+    interval: 15s
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Sources[0]
+	if s.Query != `{app="api"} |= "error"` || s.Since != 30*time.Minute || s.Limit != 500 || s.Interval != 15*time.Second {
+		t.Errorf("SourceSpec = %+v", s)
+	}
+}
+
+func TestLoadFilterRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+sources:
+  - id: api
+    type: file
+    path: /var/log/api.log
+    exclude:
+      - message: 'GET /healthz'
+      - label: user_agent
+        value: '^kube-probe/'
+    labels:
+      env: prod
+    rename_labels:
+      kubernetes_pod: pod
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cfg.Sources[0].Rules
+	if len(r.Exclude) != 2 || r.Exclude[1].Label != "user_agent" || r.Exclude[1].Value != "^kube-probe/" || r.Labels["env"] != "prod" || r.RenameLabels["kubernetes_pod"] != "pod" {
+		t.Errorf("Rules = %+v", r)
+	}
+}
+
+func TestLoadPatterns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `
+patterns:
+  similarity: 0.8
+  masks:
+    - type: k8s_pod
+    - name: order
+      regex: 'ORD-[0-9]+'
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := cfg.Patterns
+	if p.Similarity != 0.8 || len(p.Masks) != 2 || p.Masks[0].Type != "k8s_pod" || p.Masks[1].Name != "order" || p.Masks[1].Regex != "ORD-[0-9]+" {
+		t.Errorf("Patterns = %+v", p)
+	}
+}
+
 func TestLoadInvalidYAML(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "bad.yaml")
@@ -0,0 +1,121 @@
+package duckdb
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+)
+
+// flushDelay is how long Set and SetWatermark keep a value in memory before writing it. Sources
+// report a position for every record; only the latest per key is written.
+const flushDelay = time.Second
+
+type cpKey struct{ sourceID, path string }
+
+// CheckpointStore is a DuckDB-backed checkpoint.Store. Set keeps the latest checkpoint per
+// (source, path) in memory and writes them in one transaction after flushDelay; Save writes
+// what is pending, and should be called before the DB is closed.
+type CheckpointStore struct {
+	db      *DB
+	mu      sync.Mutex
+	pending map[cpKey]checkpoint.Checkpoint
+	timer   *time.Timer
+}
+
+// NewCheckpointStore returns a checkpoint.Store that uses the checkpoints table of db.
+func NewCheckpointStore(db *DB) *CheckpointStore {
+	return &CheckpointStore{db: db, pending: make(map[cpKey]checkpoint.Checkpoint)}
+}
+
+// Get returns the checkpoint for (sourceID, path), if any.
+func (s *CheckpointStore) Get(sourceID, path string) (checkpoint.Checkpoint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cp, ok := s.pending[cpKey{sourceID, path}]; ok {
+		return cp, true
+	}
+	var cp checkpoint.Checkpoint
+	err := s.db.sql.QueryRow(
+		`SELECT read_offset, inode, fingerprint FROM checkpoints WHERE source_id = ? AND path = ?`,
+		sourceID, path,
+	).Scan(&cp.Offset, &cp.Inode, &cp.Fingerprint)
+	if err != nil {
+		return checkpoint.Checkpoint{}, false
+	}
+	return cp, true
+}
+
+// Set records the checkpoint for (sourceID, path); it is written within flushDelay.
+func (s *CheckpointStore) Set(sourceID, path string, cp checkpoint.Checkpoint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[cpKey{sourceID, path}] = cp
+	if s.timer == nil {
+		s.timer = time.AfterFunc(flushDelay, func() { _ = s.Save() })
+	}
+}
+
+// List returns the checkpoints of sourceID by path.
+func (s *CheckpointStore) List(sourceID string) map[string]checkpoint.Checkpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]checkpoint.Checkpoint)
+	rows, err := s.db.sql.Query(
+		`SELECT path, read_offset, inode, fingerprint FROM checkpoints WHERE source_id = ?`, sourceID,
+	)
+	if err == nil {
+		defer rows.Close()
+		for rows.Next() {
+			var path string
+			var cp checkpoint.Checkpoint
+			if err := rows.Scan(&path, &cp.Offset, &cp.Inode, &cp.Fingerprint); err != nil {
+				continue
+			}
+			out[path] = cp
+		}
+	}
+	for k, cp := range s.pending {
+		if k.sourceID == sourceID {
+			out[k.path] = cp
+		}
+	}
+	return out
+}
+
+// Load is a no-op for DuckDB (state is already in DB).
+func (s *CheckpointStore) Load() error {
+	return nil
+}
+
+// Save writes the pending checkpoints. On error they stay pending for the next Save.
+func (s *CheckpointStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	tx, err := s.db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for k, cp := range s.pending {
+		if _, err := tx.Exec(
+			`INSERT INTO checkpoints (source_id, path, read_offset, inode, fingerprint) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (source_id, path) DO UPDATE SET read_offset = excluded.read_offset, inode = excluded.inode, fingerprint = excluded.fingerprint`,
+			k.sourceID, k.path, cp.Offset, cp.Inode, cp.Fingerprint,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	clear(s.pending)
+	return nil
+}
@@ -71,6 +71,38 @@ func (db *DB) migrate() error {
 	if err != nil {
 		return err
 	}
+	// template: added later, so databases created before it get the column here
+	_, err = db.sql.Exec(`ALTER TABLE patterns ADD COLUMN IF NOT EXISTS template VARCHAR DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	// words: the engine's generalized words of the pattern, space-separated
+	_, err = db.sql.Exec(`ALTER TABLE patterns ADD COLUMN IF NOT EXISTS words VARCHAR`)
+	if err != nil {
+		return err
+	}
+	// hash_version: scheme of hash (pattern.HashVersion); 0 for rows from before it was kept
+	_, err = db.sql.Exec(`ALTER TABLE patterns ADD COLUMN IF NOT EXISTS hash_version INTEGER DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+	// mask_fingerprint: mask rules hash was computed with (pattern.MaskFingerprint)
+	_, err = db.sql.Exec(`ALTER TABLE patterns ADD COLUMN IF NOT EXISTS mask_fingerprint VARCHAR DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	// pattern_merges: lineage of patterns the engine merged into another one
+	_, err = db.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS pattern_merges (
+			from_hash VARCHAR PRIMARY KEY,
+			into_hash VARCHAR NOT NULL,
+			level INTEGER NOT NULL,
+			merged_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
 	_, err = db.sql.Exec(`
 		CREATE TABLE IF NOT EXISTS suppressions (
 			hash VARCHAR PRIMARY KEY,
@@ -102,5 +134,30 @@ func (db *DB) migrate() error {
 	if err != nil {
 		return err
 	}
+	// checkpoints: resume position per (source, file) for file sources
+	_, err = db.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS checkpoints (
+			source_id VARCHAR NOT NULL,
+			path VARCHAR NOT NULL,
+			read_offset BIGINT NOT NULL,
+			inode UBIGINT NOT NULL,
+			fingerprint VARCHAR NOT NULL,
+			PRIMARY KEY (source_id, path)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	// watermarks: last processed cursor value per incremental query source
+	_, err = db.sql.Exec(`
+		CREATE TABLE IF NOT EXISTS watermarks (
+			source_id VARCHAR PRIMARY KEY,
+			value VARCHAR NOT NULL,
+			sql_type VARCHAR NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
 	return nil
 }
@@ -43,6 +43,73 @@ func (db *DB) LoadLatestSnapshot() (*snapshot.Snapshot, error) {
 	if err != nil {
 		return nil, err
 	}
+	patterns, err := db.snapshotPatterns(id)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot.Snapshot{Timestamp: createdAt, Patterns: patterns}, nil
+}
+
+// RehashSnapshots rewrites the hashes of all stored snapshots with Snapshot.Rehash, after a
+// hash scheme change. It returns the number of snapshots that changed.
+func (db *DB) RehashSnapshots(rehash func(snapshot.PatternEnt) string) (int, error) {
+	rows, err := db.sql.Query(`SELECT id FROM snapshots ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, id := range ids {
+		patterns, err := db.snapshotPatterns(id)
+		if err != nil {
+			return n, err
+		}
+		s := snapshot.Snapshot{Patterns: patterns}
+		if !s.Rehash(rehash) {
+			continue
+		}
+		if err := db.replaceSnapshotPatterns(id, s.Patterns); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (db *DB) replaceSnapshotPatterns(id int64, patterns []snapshot.PatternEnt) error {
+	tx, err := db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM snapshot_patterns WHERE snapshot_id = ?`, id); err != nil {
+		return err
+	}
+	for _, p := range patterns {
+		_, err = tx.Exec(
+			`INSERT INTO snapshot_patterns (snapshot_id, level, hash, sample, count) VALUES (?, ?, ?, ?, ?)`,
+			id, int(p.Level), p.Hash, p.Sample, p.Count,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (db *DB) snapshotPatterns(id int64) ([]snapshot.PatternEnt, error) {
 	rows, err := db.sql.Query(
 		`SELECT level, hash, sample, count FROM snapshot_patterns WHERE snapshot_id = ? ORDER BY level, hash`,
 		id,
@@ -66,5 +133,5 @@ func (db *DB) LoadLatestSnapshot() (*snapshot.Snapshot, error) {
 			Count:  count,
 		})
 	}
-	return &snapshot.Snapshot{Timestamp: createdAt, Patterns: patterns}, nil
+	return patterns, rows.Err()
 }
@@ -3,25 +3,36 @@ package duckdb
 import (
 	"database/sql"
 	"encoding/json"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/ailert/ailert/internal/pattern"
 	"github.com/ailert/ailert/internal/store"
 	"github.com/ailert/ailert/internal/types"
 )
 
 // Store is a DuckDB-backed PatternStore. Safe for concurrent use.
 type Store struct {
-	db *DB
-	mu sync.RWMutex
+	db     *DB
+	mu     sync.RWMutex
+	scheme store.Scheme
 }
 
 // NewStore returns a PatternStore that uses the given DB for patterns and suppressions.
 func NewStore(db *DB) *Store {
-	return &Store{db: db}
+	return &Store{db: db, scheme: store.Scheme{Version: pattern.HashVersion}}
+}
+
+// SetScheme sets the scheme patterns seen from now on are stored with.
+func (s *Store) SetScheme(scheme store.Scheme) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheme = scheme
 }
 
 // Seen records the pattern and returns true if it was new.
-func (s *Store) Seen(level types.Level, hash string, sample string) (isNew bool) {
+func (s *Store) Seen(level types.Level, hash string, sample string, template string, words []string) (isNew bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	var count int64
@@ -31,8 +42,8 @@ func (s *Store) Seen(level types.Level, hash string, sample string) (isNew bool)
 	).Scan(&count)
 	if err == sql.ErrNoRows {
 		_, err = s.db.sql.Exec(
-			`INSERT INTO patterns (level, hash, sample, count) VALUES (?, ?, ?, 1)`,
-			int(level), hash, sample,
+			`INSERT INTO patterns (level, hash, sample, template, words, count, hash_version, mask_fingerprint) VALUES (?, ?, ?, ?, ?, 1, ?, ?)`,
+			int(level), hash, sample, template, joinWords(words), s.scheme.Version, s.scheme.Masks,
 		)
 		if err != nil {
 			return true // treat as new on insert error
@@ -44,8 +55,9 @@ func (s *Store) Seen(level types.Level, hash string, sample string) (isNew bool)
 	}
 	count++
 	_, _ = s.db.sql.Exec(
-		`UPDATE patterns SET count = ?, sample = COALESCE(NULLIF(TRIM(sample), ''), ?) WHERE level = ? AND hash = ?`,
-		count, sample, int(level), hash,
+		`UPDATE patterns SET count = ?, sample = COALESCE(NULLIF(TRIM(sample), ''), ?), template = COALESCE(NULLIF(?, ''), template),
+			words = COALESCE(?, words) WHERE level = ? AND hash = ?`,
+		count, sample, template, joinWords(words), int(level), hash,
 	)
 	return false
 }
@@ -84,11 +96,175 @@ func (s *Store) IsSuppressed(hash string) bool {
 	return err == nil
 }
 
+// Merge folds pattern from into pattern into: counts are added up (into gets a row with
+// only the count when it has none), a suppression of from is copied to into, and the merge
+// is recorded in pattern_merges.
+func (s *Store) Merge(level types.Level, from, into string) {
+	if from == into {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx, err := s.db.sql.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	var count int64
+	err = tx.QueryRow(`SELECT count FROM patterns WHERE level = ? AND hash = ?`, int(level), from).Scan(&count)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return
+	default:
+		res, err := tx.Exec(`UPDATE patterns SET count = count + ? WHERE level = ? AND hash = ?`, count, int(level), into)
+		if err != nil {
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			_, err = tx.Exec(`INSERT INTO patterns (level, hash, sample, template, count, hash_version, mask_fingerprint)
+				SELECT level, ?, '', '', count, hash_version, mask_fingerprint FROM patterns WHERE level = ? AND hash = ?`, into, int(level), from)
+			if err != nil {
+				return
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM patterns WHERE level = ? AND hash = ?`, int(level), from); err != nil {
+			return
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO suppressions (hash, reason) SELECT ?, reason FROM suppressions WHERE hash = ? ON CONFLICT (hash) DO NOTHING`, into, from)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(
+		`INSERT INTO pattern_merges (from_hash, into_hash, level, merged_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (from_hash) DO UPDATE SET into_hash = excluded.into_hash, level = excluded.level, merged_at = excluded.merged_at`,
+		from, into, int(level), time.Now(),
+	)
+	if err != nil {
+		return
+	}
+	_ = tx.Commit()
+}
+
+// Resolve returns the hash a pattern was merged into, following merges, or hash itself.
+func (s *Store) Resolve(hash string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seen := map[string]bool{hash: true}
+	for {
+		var into string
+		if err := s.db.sql.QueryRow(`SELECT into_hash FROM pattern_merges WHERE from_hash = ?`, hash).Scan(&into); err != nil || seen[into] {
+			return hash
+		}
+		seen[into] = true
+		hash = into
+	}
+}
+
+// Rehash applies renames after a hash scheme change and marks every pattern with scheme;
+// see store.Store.Rehash. It runs in one transaction and changes nothing if any step fails.
+func (s *Store) Rehash(renames []store.Rename, scheme store.Scheme) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.rehash(renames, scheme); err != nil {
+		return err
+	}
+	s.scheme = scheme
+	return nil
+}
+
+func (s *Store) rehash(renames []store.Rename, scheme store.Scheme) error {
+	tx, err := s.db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	type row struct {
+		level              int
+		hash, sample, tmpl string
+		count              int64
+	}
+	// Take every renamed row out first, so a new hash may equal another row's old one.
+	var moved []row
+	reasons := make(map[string]string)
+	for _, r := range renames {
+		if r.From == r.To {
+			continue
+		}
+		m := row{level: int(r.Level), hash: r.To}
+		err := tx.QueryRow(`SELECT sample, COALESCE(template, ''), count FROM patterns WHERE level = ? AND hash = ?`,
+			int(r.Level), r.From).Scan(&m.sample, &m.tmpl, &m.count)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		default:
+			moved = append(moved, m)
+		}
+		var reason string
+		if err := tx.QueryRow(`SELECT reason FROM suppressions WHERE hash = ?`, r.From).Scan(&reason); err == nil {
+			reasons[r.To] = reason
+		}
+	}
+	targets := make(map[string]bool, len(renames))
+	for _, r := range renames {
+		targets[r.To] = true
+	}
+	for h := range targets {
+		if _, err := tx.Exec(`DELETE FROM pattern_merges WHERE from_hash = ?`, h); err != nil {
+			return err
+		}
+	}
+	for _, r := range renames {
+		if r.From == r.To {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM patterns WHERE level = ? AND hash = ?`, int(r.Level), r.From); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM suppressions WHERE hash = ?`, r.From); err != nil {
+			return err
+		}
+		if targets[r.From] {
+			continue // reused by another pattern, which must not resolve away
+		}
+		_, err := tx.Exec(
+			`INSERT INTO pattern_merges (from_hash, into_hash, level, merged_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (from_hash) DO UPDATE SET into_hash = excluded.into_hash, level = excluded.level, merged_at = excluded.merged_at`,
+			r.From, r.To, int(r.Level), time.Now(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, m := range moved {
+		_, err := tx.Exec(
+			`INSERT INTO patterns (level, hash, sample, template, count) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (level, hash) DO UPDATE SET count = patterns.count + excluded.count`,
+			m.level, m.hash, m.sample, m.tmpl, m.count,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for h, reason := range reasons {
+		if _, err := tx.Exec(`INSERT INTO suppressions (hash, reason) VALUES (?, ?) ON CONFLICT (hash) DO NOTHING`, h, reason); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE patterns SET hash_version = ?, mask_fingerprint = ?`, scheme.Version, scheme.Masks); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // ListSeen returns all stored patterns.
 func (s *Store) ListSeen() []store.PatternInfo {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	rows, err := s.db.sql.Query(`SELECT level, hash, sample, count FROM patterns ORDER BY level, hash`)
+	rows, err := s.db.sql.Query(`SELECT level, hash, sample, COALESCE(template, ''), words, count, COALESCE(hash_version, 0), COALESCE(mask_fingerprint, '')
+		FROM patterns ORDER BY level, hash`)
 	if err != nil {
 		return nil
 	}
@@ -96,21 +272,62 @@ func (s *Store) ListSeen() []store.PatternInfo {
 	var out []store.PatternInfo
 	for rows.Next() {
 		var level int
-		var hash, sample string
+		var hash, sample, template, masks string
+		var words sql.NullString
 		var count int64
-		if err := rows.Scan(&level, &hash, &sample, &count); err != nil {
+		var version int
+		if err := rows.Scan(&level, &hash, &sample, &template, &words, &count, &version, &masks); err != nil {
 			continue
 		}
 		out = append(out, store.PatternInfo{
-			Level:  types.Level(level),
-			Hash:   hash,
-			Sample: sample,
-			Count:  count,
+			Level:    types.Level(level),
+			Hash:     hash,
+			Sample:   sample,
+			Template: template,
+			Words:    splitWords(words),
+			Count:    count,
+			Version:  version,
+			Masks:    masks,
 		})
 	}
 	return out
 }
 
+// ListSuppressed returns the suppressed hashes with their reasons.
+func (s *Store) ListSuppressed() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]string)
+	rows, err := s.db.sql.Query(`SELECT hash, reason FROM suppressions`)
+	if err != nil {
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash, reason string
+		if err := rows.Scan(&hash, &reason); err != nil {
+			continue
+		}
+		out[hash] = reason
+	}
+	return out
+}
+
+// joinWords stores words space-separated; nil is stored as NULL ("not kept").
+func joinWords(words []string) any {
+	if words == nil {
+		return nil
+	}
+	return strings.Join(words, " ")
+}
+
+func splitWords(s sql.NullString) []string {
+	if !s.Valid {
+		return nil
+	}
+	return strings.Fields(s.String)
+}
+
 // Load is a no-op for DuckDB (state is already in DB).
 func (s *Store) Load() error {
 	return nil
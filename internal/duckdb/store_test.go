@@ -2,9 +2,13 @@ package duckdb
 
 import (
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ailert/ailert/internal/checkpoint"
 	"github.com/ailert/ailert/internal/snapshot"
+	"github.com/ailert/ailert/internal/store"
 	"github.com/ailert/ailert/internal/types"
 )
 
@@ -22,10 +26,10 @@ func TestStore_Seen_ListSeen_Suppress(t *testing.T) {
 	}
 
 	// New pattern
-	if !st.Seen(types.LevelError, "h1", "sample one") {
+	if !st.Seen(types.LevelError, "h1", "sample one", "", nil) {
 		t.Error("expected first Seen to be new")
 	}
-	if st.Seen(types.LevelError, "h1", "sample one") {
+	if st.Seen(types.LevelError, "h1", "sample one", "", nil) {
 		t.Error("expected second Seen to be known")
 	}
 	if c := st.GetCount(types.LevelError, "h1"); c != 2 {
@@ -54,7 +58,7 @@ func TestStore_Persist(t *testing.T) {
 		t.Fatal(err)
 	}
 	st1 := NewStore(db1)
-	st1.Seen(types.LevelWarn, "w1", "warn sample")
+	st1.Seen(types.LevelWarn, "w1", "warn sample", "warn <NUM>", nil)
 	st1.Suppress("w1", "noise")
 	db1.Close()
 
@@ -68,7 +72,7 @@ func TestStore_Persist(t *testing.T) {
 		t.Error("expected w1 still suppressed after reopen")
 	}
 	list := st2.ListSeen()
-	if len(list) != 1 || list[0].Hash != "w1" {
+	if len(list) != 1 || list[0].Hash != "w1" || list[0].Template != "warn <NUM>" {
 		t.Errorf("expected 1 pattern w1 after reopen, got %v", list)
 	}
 }
@@ -112,4 +116,240 @@ func TestSnapshot_SaveLoad(t *testing.T) {
 		t.Fatalf("expected 2 patterns, got %d", len(snap.Patterns))
 	}
 	_ = id
-}
\ No newline at end of file
+}
+
+func TestCheckpointStore_GetSet(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.duckdb")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cps := NewCheckpointStore(db)
+	if _, ok := cps.Get("app", "/var/log/app.log"); ok {
+		t.Fatal("expected no checkpoint")
+	}
+	cp := checkpoint.Checkpoint{Offset: 128, Inode: 1 << 40, Fingerprint: "abc"}
+	cps.Set("app", "/var/log/app.log", cp)
+	cp.Offset = 256
+	cps.Set("app", "/var/log/app.log", cp)
+	if got, ok := cps.Get("app", "/var/log/app.log"); !ok || got != cp {
+		t.Errorf("pending Get = %+v, %v; want %+v", got, ok, cp)
+	}
+	if l := cps.List("app"); len(l) != 1 || l["/var/log/app.log"] != cp {
+		t.Errorf("pending List = %+v", l)
+	}
+	if n := countRows(t, db, "checkpoints"); n != 0 {
+		t.Errorf("%d checkpoint rows written before Save, want 0", n)
+	}
+	if err := cps.Save(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db2, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	got, ok := NewCheckpointStore(db2).Get("app", "/var/log/app.log")
+	if !ok || got != cp {
+		t.Errorf("after reopen Get = %+v, %v; want %+v", got, ok, cp)
+	}
+	if l := NewCheckpointStore(db2).List("app"); len(l) != 1 || l["/var/log/app.log"] != cp {
+		t.Errorf("List = %+v", l)
+	}
+}
+
+func TestWatermarkStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.duckdb")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wms := NewWatermarkStore(db)
+	if _, _, ok := wms.Watermark("history"); ok {
+		t.Fatal("expected no watermark")
+	}
+	wms.SetWatermark("history", "2024-01-01 00:00:00", "TIMESTAMP")
+	wms.SetWatermark("history", "2024-01-02 00:00:00", "TIMESTAMP")
+	if value, _, ok := wms.Watermark("history"); !ok || value != "2024-01-02 00:00:00" {
+		t.Errorf("pending Watermark = %q, %v", value, ok)
+	}
+	if n := countRows(t, db, "watermarks"); n != 0 {
+		t.Errorf("%d watermark rows written before Save, want 0", n)
+	}
+	if err := wms.Save(); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db2, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+	value, sqlType, ok := NewWatermarkStore(db2).Watermark("history")
+	if !ok || value != "2024-01-02 00:00:00" || sqlType != "TIMESTAMP" {
+		t.Errorf("after reopen Watermark = %q, %q, %v", value, sqlType, ok)
+	}
+}
+
+func TestCheckpointStore_FlushDelay(t *testing.T) {
+	db, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cps := NewCheckpointStore(db)
+	for i := int64(1); i <= 100; i++ {
+		cps.Set("app", "/var/log/app.log", checkpoint.Checkpoint{Offset: i})
+	}
+	deadline := time.Now().Add(5 * flushDelay)
+	for countRows(t, db, "checkpoints") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("pending checkpoint not written after flushDelay")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var off int64
+	if err := db.sql.QueryRow(`SELECT read_offset FROM checkpoints`).Scan(&off); err != nil || off != 100 {
+		t.Errorf("stored offset = %d, %v; want 100", off, err)
+	}
+}
+
+func countRows(t *testing.T, db *DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.sql.QueryRow(`SELECT count(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStore_Merge(t *testing.T) {
+	db, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	st := NewStore(db)
+	st.Seen(types.LevelError, "a", "user alice logged in", "user <*> logged in", []string{"user", "<*>", "logged", "in"})
+	st.Seen(types.LevelError, "b", "user mary ann logged in", "", nil)
+	st.Seen(types.LevelError, "b", "user jo bee logged in", "", nil)
+	st.Seen(types.LevelWarn, "c", "moved", "", nil)
+	st.Suppress("b", "noisy")
+	st.Merge(types.LevelError, "b", "a")
+	st.Merge(types.LevelWarn, "c", "d")
+	st.Merge(types.LevelWarn, "d", "e")
+	if c := st.GetCount(types.LevelError, "a"); c != 3 {
+		t.Errorf("count of a = %d, want 3", c)
+	}
+	if st.GetCount(types.LevelError, "b") != 0 || st.GetCount(types.LevelWarn, "e") != 1 || len(st.ListSeen()) != 2 {
+		t.Errorf("ListSeen = %+v", st.ListSeen())
+	}
+	for _, p := range st.ListSeen() {
+		switch p.Hash {
+		case "a":
+			if p.Sample != "user alice logged in" || strings.Join(p.Words, " ") != "user <*> logged in" {
+				t.Errorf("a = %+v", p)
+			}
+		case "e":
+			if p.Sample != "" || p.Template != "" || p.Words != nil {
+				t.Errorf("e took over the sample of c: %+v", p)
+			}
+		}
+	}
+	if !st.IsSuppressed("a") {
+		t.Error("suppression of b not carried over to a")
+	}
+	if got := st.Resolve("c"); got != "e" {
+		t.Errorf("Resolve(c) = %q, want e", got)
+	}
+	if got := st.Resolve("x"); got != "x" {
+		t.Errorf("Resolve(x) = %q", got)
+	}
+}
+
+func TestStore_Rehash(t *testing.T) {
+	db, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	st := NewStore(db)
+	st.Seen(types.LevelError, "old-a", "disk full on sda", "", nil)
+	st.Seen(types.LevelError, "old-b", "disk full on sdb", "", nil)
+	st.Seen(types.LevelError, "old-c", "cache miss", "", nil)
+	st.Seen(types.LevelError, "old-c", "cache miss", "", nil)
+	st.Suppress("old-a", "noisy")
+	st.Suppress("old-c", "expected")
+	if err := st.Rehash([]store.Rename{
+		{Level: types.LevelError, From: "old-a", To: "new-ab"},
+		{Level: types.LevelError, From: "old-b", To: "new-ab"},
+		{Level: types.LevelError, From: "old-c", To: "old-a"},
+	}, store.Scheme{Version: 2, Masks: "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	for h, want := range map[string]int64{"new-ab": 2, "old-a": 2, "old-b": 0, "old-c": 0} {
+		if c := st.GetCount(types.LevelError, h); c != want {
+			t.Errorf("count of %s = %d, want %d", h, c, want)
+		}
+	}
+	for _, p := range st.ListSeen() {
+		if p.Version != 2 || p.Masks != "m1" {
+			t.Errorf("%s: scheme %d %q, want 2 m1", p.Hash, p.Version, p.Masks)
+		}
+	}
+	if !st.IsSuppressed("new-ab") || st.IsSuppressed("old-c") {
+		t.Error("suppressions not moved to the new hashes")
+	}
+	// old-a is live again (it holds old-c), so it must not resolve to new-ab.
+	for from, want := range map[string]string{"old-b": "new-ab", "old-c": "old-a", "old-a": "old-a"} {
+		if r := st.Resolve(from); r != want {
+			t.Errorf("Resolve(%s) = %q, want %q", from, r, want)
+		}
+	}
+	if sup := st.ListSuppressed(); len(sup) != 2 || sup["new-ab"] != "noisy" || sup["old-a"] != "expected" {
+		t.Errorf("ListSuppressed = %v", sup)
+	}
+	// Patterns seen from now on carry the new scheme.
+	st.Seen(types.LevelWarn, "later", "slow", "", nil)
+	for _, p := range st.ListSeen() {
+		if p.Hash == "later" && (p.Version != 2 || p.Masks != "m1") {
+			t.Errorf("later: %+v", p)
+		}
+	}
+
+	if _, err := db.SaveSnapshot([]snapshot.PatternEnt{
+		{Level: types.LevelError, Hash: "old-a", Sample: "disk full on sda", Count: 1},
+		{Level: types.LevelError, Hash: "old-b", Sample: "disk full on sdb", Count: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	n, err := db.RehashSnapshots(func(p snapshot.PatternEnt) string { return "new-ab" })
+	if err != nil || n != 1 {
+		t.Fatalf("RehashSnapshots = %d, %v", n, err)
+	}
+	snap, err := db.LoadLatestSnapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Patterns) != 1 || snap.Patterns[0].Hash != "new-ab" || snap.Patterns[0].Count != 4 {
+		t.Errorf("snapshot = %+v", snap.Patterns)
+	}
+}
+
+func TestStore_RehashError(t *testing.T) {
+	db, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := NewStore(db)
+	st.Seen(types.LevelError, "old", "disk full", "", nil)
+	db.Close()
+	err = st.Rehash([]store.Rename{{Level: types.LevelError, From: "old", To: "new"}}, store.Scheme{Version: 2})
+	if err == nil {
+		t.Error("Rehash on a closed database should fail")
+	}
+}
@@ -0,0 +1,81 @@
+package duckdb
+
+import (
+	"sync"
+	"time"
+)
+
+type watermark struct{ value, sqlType string }
+
+// WatermarkStore persists the watermarks of incremental query sources in the watermarks
+// table (source.Watermarks). Like CheckpointStore, it keeps the latest watermark per source
+// in memory and writes after flushDelay; Save writes what is pending.
+type WatermarkStore struct {
+	db      *DB
+	mu      sync.Mutex
+	pending map[string]watermark
+	timer   *time.Timer
+}
+
+// NewWatermarkStore returns a watermark store that uses the watermarks table of db.
+func NewWatermarkStore(db *DB) *WatermarkStore {
+	return &WatermarkStore{db: db, pending: make(map[string]watermark)}
+}
+
+// Watermark returns the stored watermark of sourceID, if any.
+func (s *WatermarkStore) Watermark(sourceID string) (value, sqlType string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if wm, ok := s.pending[sourceID]; ok {
+		return wm.value, wm.sqlType, true
+	}
+	err := s.db.sql.QueryRow(
+		`SELECT value, sql_type FROM watermarks WHERE source_id = ?`, sourceID,
+	).Scan(&value, &sqlType)
+	if err != nil {
+		return "", "", false
+	}
+	return value, sqlType, true
+}
+
+// SetWatermark records the watermark of sourceID; it is written within flushDelay.
+func (s *WatermarkStore) SetWatermark(sourceID, value, sqlType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[sourceID] = watermark{value, sqlType}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(flushDelay, func() { _ = s.Save() })
+	}
+}
+
+// Save writes the pending watermarks. On error they stay pending for the next Save.
+func (s *WatermarkStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	tx, err := s.db.sql.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for id, wm := range s.pending {
+		if _, err := tx.Exec(
+			`INSERT INTO watermarks (source_id, value, sql_type) VALUES (?, ?, ?)
+			ON CONFLICT (source_id) DO UPDATE SET value = excluded.value, sql_type = excluded.sql_type`,
+			id, wm.value, wm.sqlType,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	clear(s.pending)
+	return nil
+}
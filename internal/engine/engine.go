@@ -13,29 +13,59 @@ type Result struct {
 	Level    types.Level
 	Hash     string
 	Sample   string
+	Template string   // template of the pattern, e.g. "connection refused from <IP>"
+	Params   []string // values of this record's template placeholders, in order
+	Merged   []string // patterns merged into Hash while processing this record
 	IsNew    bool
 	Suppressed bool
 	Count   int64
 }
 
-type levelHash struct {
-	level types.Level
-	hash  string
+// Options tunes pattern matching. Zero values select the pattern package defaults.
+type Options struct {
+	Similarity float64            `yaml:"similarity"` // share of equal tokens to join a pattern (default 0.7)
+	Depth      int                `yaml:"depth"`      // leading tokens that route a line in the parse tree (default 2)
+	Masks      []pattern.MaskRule `yaml:"masks"`      // applied in order before the template is built
 }
 
-// Engine runs the pattern extraction and store lookup.
+// Engine runs the pattern extraction and store lookup. Patterns are clustered per level in
+// a Drain-style parse tree, so matching does not slow down as patterns accumulate. Lines
+// are tokenized concurrently; matching and the store update that follows take a per-level
+// lock, so a pattern the tree merges away is never counted again under its old ID. The
+// trees are seeded from the store, so pattern IDs stay the same across runs.
 type Engine struct {
-	mu       sync.RWMutex
-	store    store.PatternStore
-	patterns map[levelHash]*pattern.Pattern // (level, hash) -> pattern for WeakEqual within same level
+	store  store.PatternStore
+	masker *pattern.Masker
+	trees  map[types.Level]*pattern.Tree
+	locks  map[types.Level]*sync.Mutex
 }
 
 // New returns an engine that uses the given store.
 func New(st store.PatternStore) *Engine {
-	return &Engine{
-		store:   st,
-		patterns: make(map[levelHash]*pattern.Pattern),
+	e, _ := NewWithOptions(st, Options{})
+	return e
+}
+
+// NewWithOptions returns an engine that uses the given store and matching options. The
+// store should be loaded already; new patterns are stored with the current hash scheme and
+// mask rules. It fails if a mask rule is invalid.
+func NewWithOptions(st store.PatternStore, opts Options) (*Engine, error) {
+	m, err := pattern.NewMasker(opts.Masks)
+	if err != nil {
+		return nil, err
 	}
+	st.SetScheme(store.Scheme{Version: pattern.HashVersion, Masks: pattern.MaskFingerprint(opts.Masks)})
+	e := &Engine{store: st, masker: m, trees: make(map[types.Level]*pattern.Tree), locks: make(map[types.Level]*sync.Mutex)}
+	for l := types.LevelUnknown; l <= types.LevelError; l++ {
+		e.trees[l] = &pattern.Tree{Similarity: opts.Similarity, Depth: opts.Depth}
+		e.locks[l] = &sync.Mutex{}
+	}
+	for _, p := range st.ListSeen() {
+		if tree := e.trees[p.Level]; tree != nil && p.Sample != "" {
+			tree.Add(pattern.NewMasked(p.Sample, m), p.Hash, p.Template, p.Words)
+		}
+	}
+	return e, nil
 }
 
 // Process takes a record and returns the pattern result (hash, new/known, suppressed).
@@ -44,36 +74,34 @@ func (e *Engine) Process(r *types.Record) Result {
 	if level == types.LevelUnknown {
 		level = pattern.DetectLevel(r.Message)
 	}
-	pat := pattern.New(r.Message)
+	pat := pattern.NewMasked(r.Message, e.masker)
 	hash := pat.Hash()
 
-	if st := e.store; st.IsSuppressed(hash) {
-		return Result{Level: level, Hash: hash, Sample: r.Message, IsNew: false, Suppressed: true, Count: 0}
+	// The cluster ID is the hash of the first pattern of the cluster; suppressing either
+	// the line's own template or its cluster suppresses the line. Clusters the tree merged
+	// hand their counts and suppressions over to the surviving ID first.
+	id, tmpl := hash, pat.String()
+	var words, merged []string
+	if mu := e.locks[level]; mu != nil {
+		mu.Lock()
+		defer mu.Unlock()
 	}
-
-	e.mu.Lock()
-	var existingHash string
-	lh := levelHash{level: level, hash: hash}
-	for k, p := range e.patterns {
-		if k.level != level {
-			continue
-		}
-		if p.WeakEqual(pat) {
-			existingHash = k.hash
-			break
+	if tree := e.trees[level]; tree != nil {
+		m := tree.Match(pat)
+		id, tmpl, words, merged = m.ID, m.Template, m.Words, m.Merged
+		for _, from := range merged {
+			e.store.Merge(level, from, id)
 		}
 	}
-	if existingHash != "" {
-		hash = existingHash
-	} else {
-		e.patterns[lh] = pat
+	if st := e.store; st.IsSuppressed(hash) || st.IsSuppressed(id) {
+		return Result{Level: level, Hash: id, Sample: r.Message, Template: tmpl, Params: pat.Params(), Merged: merged, IsNew: false, Suppressed: true, Count: 0}
 	}
-	e.mu.Unlock()
+	hash = id
 
-	isNew := e.store.Seen(level, hash, r.Message)
+	isNew := e.store.Seen(level, hash, r.Message, tmpl, words)
 	count := e.store.GetCount(level, hash)
 	return Result{
-		Level: level, Hash: hash, Sample: r.Message,
+		Level: level, Hash: hash, Sample: r.Message, Template: tmpl, Params: pat.Params(), Merged: merged,
 		IsNew: isNew, Suppressed: false, Count: count,
 	}
 }
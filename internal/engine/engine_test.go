@@ -1,8 +1,11 @@
 package engine
 
 import (
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/ailert/ailert/internal/pattern"
 	"github.com/ailert/ailert/internal/store"
 	"github.com/ailert/ailert/internal/types"
 )
@@ -58,3 +61,153 @@ func TestEngineProcess_DifferentLevelsSameTemplate(t *testing.T) {
 		t.Errorf("both should be new (different level keys): r1.IsNew=%v r2.IsNew=%v", r1.IsNew, r2.IsNew)
 	}
 }
+
+func TestEngineProcess_Clusters(t *testing.T) {
+	st := store.New("")
+	eng := New(st)
+	// The templates differ in one of five words, so the second line joins the first pattern.
+	r1 := eng.Process(&types.Record{Message: "ERROR job sync failed on primary"})
+	r2 := eng.Process(&types.Record{Message: "ERROR job sync failed on replica"})
+	if r2.IsNew || r2.Hash != r1.Hash || r2.Count != 2 {
+		t.Errorf("similar line: %+v (first %+v)", r2, r1)
+	}
+	st.Suppress(r1.Hash, "noise")
+	if r := eng.Process(&types.Record{Message: "ERROR job sync failed on standby"}); !r.Suppressed {
+		t.Error("line of a suppressed cluster not suppressed")
+	}
+
+	strict, err := NewWithOptions(store.New(""), Options{Similarity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict.Process(&types.Record{Message: "ERROR job sync failed on primary"})
+	if r := strict.Process(&types.Record{Message: "ERROR job sync failed on replica"}); !r.IsNew {
+		t.Error("with similarity 1 a differing word should be a new pattern")
+	}
+}
+
+func TestEngineProcess_Masks(t *testing.T) {
+	eng, err := NewWithOptions(store.New(""), Options{Masks: []pattern.MaskRule{{Type: pattern.MaskK8sPod}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1 := eng.Process(&types.Record{Message: "ERROR pod api-7d9f8b6c5-xk2lp restarted"})
+	r2 := eng.Process(&types.Record{Message: "ERROR pod api-7d9f8b6c5-q8wz4 restarted"})
+	if r2.IsNew || r2.Template != "ERROR pod <POD> restarted" || len(r2.Params) != 1 || r2.Params[0] != "api-7d9f8b6c5-q8wz4" {
+		t.Errorf("second pod: %+v (first %+v)", r2, r1)
+	}
+	if _, err := NewWithOptions(store.New(""), Options{Masks: []pattern.MaskRule{{Type: "bogus"}}}); err == nil {
+		t.Error("expected an error for an unknown mask type")
+	}
+}
+
+func TestEngineProcess_MergeAndReload(t *testing.T) {
+	st := store.New("")
+	eng := New(st)
+	first := eng.Process(&types.Record{Message: "ERROR sync failed for user alice smith"})
+	eng.Process(&types.Record{Message: "ERROR sync failed for user bob smith"})
+	long := eng.Process(&types.Record{Message: "ERROR sync failed for user mary ann jones"})
+	st.Suppress(long.Hash, "noisy")
+	eng.Process(&types.Record{Message: "ERROR sync failed for user jo bee jones"})
+	eng.Process(&types.Record{Message: "ERROR sync failed for user carol white"})
+	r := eng.Process(&types.Record{Message: "ERROR sync failed for user jo bee brown"})
+	if r.Hash != first.Hash || len(r.Merged) != 1 || r.Merged[0] != long.Hash {
+		t.Fatalf("merge: %+v", r)
+	}
+	// Counts and the suppression moved to the surviving pattern: three short lines plus
+	// the long one seen before it was suppressed.
+	if l := st.ListSeen(); len(l) != 1 || l[0].Hash != first.Hash || l[0].Count != 4 {
+		t.Errorf("ListSeen = %+v", l)
+	}
+	if !r.Suppressed || st.Resolve(long.Hash) != first.Hash {
+		t.Errorf("after merge: %+v", r)
+	}
+
+	// A new engine on the same store keeps the ID and generalized template.
+	eng = New(st)
+	r = eng.Process(&types.Record{Message: "ERROR sync failed for user dave white"})
+	if r.IsNew || r.Hash != first.Hash || r.Template != "ERROR sync failed for user <*> <*>" {
+		t.Errorf("after reload: %+v", r)
+	}
+}
+
+func TestEngineProcess_ReloadGeneralized(t *testing.T) {
+	st := store.New("")
+	eng := New(st)
+	first := eng.Process(&types.Record{Message: "job run aaa bbb ccc ddd eee fff ggg fin"})
+	eng.Process(&types.Record{Message: "job run xxx yyy zzz ddd eee fff ggg fin"})
+	eng.Process(&types.Record{Message: "job run aaa bbb ccc www vvv uuu ggg fin"})
+	// Only the generalized words match this line; the first sample alone does not.
+	line := &types.Record{Message: "job run qqq rrr sss ttt uuu vvv ggg fin"}
+	if r := eng.Process(line); r.IsNew || r.Hash != first.Hash {
+		t.Fatalf("before reload: %+v", r)
+	}
+	eng = New(st)
+	if r := eng.Process(line); r.IsNew || r.Hash != first.Hash {
+		t.Errorf("after reload: %+v", r)
+	}
+}
+
+// stallStore holds up the IsSuppressed check of one hash until a Merge happens (or a
+// timeout passes), so a line can be caught between matching and counting.
+type stallStore struct {
+	store.PatternStore
+	stall  string
+	merged chan struct{}
+	once   sync.Once
+}
+
+func (s *stallStore) IsSuppressed(hash string) bool {
+	if hash == s.stall {
+		select {
+		case <-s.merged:
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	return s.PatternStore.IsSuppressed(hash)
+}
+
+func (s *stallStore) Merge(level types.Level, from, into string) {
+	s.PatternStore.Merge(level, from, into)
+	s.once.Do(func() { close(s.merged) })
+}
+
+// A line matched to a pattern that another line is merging away must not bring the merged
+// ID back into the store as a new pattern.
+func TestEngineProcess_ConcurrentMerge(t *testing.T) {
+	st := store.New("")
+	eng := New(st)
+	eng.Process(&types.Record{Message: "ERROR sync failed for user mary ann jones"})
+	short := eng.Process(&types.Record{Message: "ERROR sync failed for user alice smith"})
+	for _, line := range []string{
+		"ERROR sync failed for user jo bee jones",
+		"ERROR sync failed for user bob smith",
+		"ERROR sync failed for user carol white",
+	} {
+		eng.Process(&types.Record{Message: line})
+	}
+	again := "ERROR sync failed for user bob smith"
+	stalled := &stallStore{PatternStore: st, stall: pattern.New(again).Hash(), merged: make(chan struct{})}
+	eng.store = stalled
+
+	done := make(chan Result)
+	go func() { done <- eng.Process(&types.Record{Message: again}) }()
+	time.Sleep(50 * time.Millisecond) // let it match the long pattern and stall
+	r := eng.Process(&types.Record{Message: "ERROR sync failed for user jo bee brown"})
+	if len(r.Merged) != 1 || r.Merged[0] != short.Hash {
+		t.Fatalf("merge: %+v", r)
+	}
+	if r := <-done; r.IsNew {
+		t.Errorf("stalled line counted as a new pattern: %+v", r)
+	}
+	var total int64
+	for _, p := range st.ListSeen() {
+		total += p.Count
+		if p.Hash == short.Hash {
+			t.Errorf("merged pattern %s is back in the store: %+v", p.Hash, st.ListSeen())
+		}
+	}
+	if total != 7 {
+		t.Errorf("counted %d lines, want 7", total)
+	}
+}
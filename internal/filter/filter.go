@@ -0,0 +1,152 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// Rules configures the per-source processing stage that runs between a source and the
+// engine. Labels are adjusted first: CopyLabels (source -> destination label), then
+// RenameLabels (old -> new name), then static Labels, which only fill labels the record does
+// not already have. Then the record is dropped if it matches any Exclude rule, or if Include
+// is set and it matches none of those rules.
+type Rules struct {
+	Include      []Match           `yaml:"include"`
+	Exclude      []Match           `yaml:"exclude"`
+	Labels       map[string]string `yaml:"labels"`        // static labels, e.g. env, cluster, team
+	RenameLabels map[string]string `yaml:"rename_labels"` // old name -> new name
+	CopyLabels   map[string]string `yaml:"copy_labels"`   // source label -> destination label
+}
+
+// Match selects records. Every condition that is set must hold: Message is a regex on the
+// message; Label names a label that must be present, and Value, if set, is a regex its
+// value must match.
+type Match struct {
+	Message string `yaml:"message"`
+	Label   string `yaml:"label"`
+	Value   string `yaml:"value"`
+}
+
+// Stage applies compiled Rules to records. A nil Stage keeps every record unchanged.
+type Stage struct {
+	include []matcher
+	exclude []matcher
+	labels  map[string]string
+	renames [][2]string
+	copies  [][2]string
+}
+
+type matcher struct {
+	message *regexp.Regexp
+	label   string
+	value   *regexp.Regexp
+}
+
+// New compiles rules. It returns nil when there is nothing to do.
+func New(r Rules) (*Stage, error) {
+	if len(r.Include) == 0 && len(r.Exclude) == 0 && len(r.Labels) == 0 && len(r.RenameLabels) == 0 && len(r.CopyLabels) == 0 {
+		return nil, nil
+	}
+	s := &Stage{labels: r.Labels, renames: sortedPairs(r.RenameLabels), copies: sortedPairs(r.CopyLabels)}
+	var err error
+	if s.include, err = compile("include", r.Include); err != nil {
+		return nil, err
+	}
+	if s.exclude, err = compile("exclude", r.Exclude); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func compile(kind string, ms []Match) ([]matcher, error) {
+	out := make([]matcher, 0, len(ms))
+	for i, m := range ms {
+		if m.Message == "" && m.Label == "" {
+			return nil, fmt.Errorf("%s[%d]: set message and/or label", kind, i)
+		}
+		if m.Value != "" && m.Label == "" {
+			return nil, fmt.Errorf("%s[%d]: value needs a label", kind, i)
+		}
+		c := matcher{label: m.Label}
+		var err error
+		if m.Message != "" {
+			if c.message, err = regexp.Compile(m.Message); err != nil {
+				return nil, fmt.Errorf("%s[%d]: message: %w", kind, i, err)
+			}
+		}
+		if m.Value != "" {
+			if c.value, err = regexp.Compile(m.Value); err != nil {
+				return nil, fmt.Errorf("%s[%d]: value: %w", kind, i, err)
+			}
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// sortedPairs returns the map entries ordered by key so rules apply deterministically.
+func sortedPairs(m map[string]string) [][2]string {
+	out := make([][2]string, 0, len(m))
+	for k, v := range m {
+		out = append(out, [2]string{k, v})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
+
+// Apply adjusts rec's labels and reports whether the record should be kept.
+func (s *Stage) Apply(rec *types.Record) bool {
+	if s == nil {
+		return true
+	}
+	for _, c := range s.copies {
+		if v, ok := rec.Labels[c[0]]; ok {
+			rec.Labels[c[1]] = v
+		}
+	}
+	for _, r := range s.renames {
+		if v, ok := rec.Labels[r[0]]; ok {
+			delete(rec.Labels, r[0])
+			rec.Labels[r[1]] = v
+		}
+	}
+	for k, v := range s.labels {
+		if _, ok := rec.Labels[k]; ok {
+			continue
+		}
+		if rec.Labels == nil {
+			rec.Labels = make(map[string]string)
+		}
+		rec.Labels[k] = v
+	}
+	for _, m := range s.exclude {
+		if m.match(rec) {
+			return false
+		}
+	}
+	if len(s.include) == 0 {
+		return true
+	}
+	for _, m := range s.include {
+		if m.match(rec) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *matcher) match(rec *types.Record) bool {
+	if m.message != nil && !m.message.MatchString(rec.Message) {
+		return false
+	}
+	if m.label != "" {
+		v, ok := rec.Labels[m.label]
+		if !ok || (m.value != nil && !m.value.MatchString(v)) {
+			return false
+		}
+	}
+	return true
+}
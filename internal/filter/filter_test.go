@@ -0,0 +1,95 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func TestStage_Filters(t *testing.T) {
+	s, err := New(Rules{
+		Exclude: []Match{
+			{Message: `GET /healthz`},
+			{Label: "user_agent", Value: `^kube-probe/`},
+		},
+		Include: []Match{
+			{Message: `(?i)error|warn`},
+			{Label: "audit"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		msg    string
+		labels map[string]string
+		keep   bool
+	}{
+		{"ERROR db down", nil, true},
+		{"ERROR GET /healthz failed", nil, false},
+		{"WARN slow", map[string]string{"user_agent": "kube-probe/1.29"}, false},
+		{"WARN slow", map[string]string{"user_agent": "curl/8"}, true},
+		{"INFO user login", map[string]string{"audit": "true"}, true},
+		{"INFO started", nil, false},
+	} {
+		rec := types.Record{Message: tt.msg, Labels: tt.labels}
+		if got := s.Apply(&rec); got != tt.keep {
+			t.Errorf("Apply(%q, %v) = %v, want %v", tt.msg, tt.labels, got, tt.keep)
+		}
+	}
+}
+
+func TestStage_Labels(t *testing.T) {
+	s, err := New(Rules{
+		Labels:       map[string]string{"env": "prod", "team": "payments"},
+		CopyLabels:   map[string]string{"host": "instance"},
+		RenameLabels: map[string]string{"kubernetes_pod": "pod"},
+		Exclude:      []Match{{Label: "pod", Value: `^debug-`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := types.Record{Message: "x", Labels: map[string]string{"host": "web-1", "kubernetes_pod": "api-1", "env": "staging"}}
+	if !s.Apply(&rec) {
+		t.Fatal("record dropped")
+	}
+	want := map[string]string{"host": "web-1", "instance": "web-1", "pod": "api-1", "env": "staging", "team": "payments"}
+	if len(rec.Labels) != len(want) {
+		t.Errorf("labels = %v", rec.Labels)
+	}
+	for k, v := range want {
+		if rec.Labels[k] != v {
+			t.Errorf("label %s = %q, want %q", k, rec.Labels[k], v)
+		}
+	}
+	// Exclude sees renamed labels.
+	rec = types.Record{Message: "x", Labels: map[string]string{"kubernetes_pod": "debug-shell"}}
+	if s.Apply(&rec) {
+		t.Error("record from renamed debug pod kept")
+	}
+	// Static labels reach records without any.
+	rec = types.Record{Message: "x"}
+	if !s.Apply(&rec) || rec.Labels["env"] != "prod" {
+		t.Errorf("labels = %v", rec.Labels)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if s, err := New(Rules{}); s != nil || err != nil {
+		t.Errorf("New(empty) = %v, %v", s, err)
+	}
+	var nilStage *Stage
+	if !nilStage.Apply(&types.Record{}) {
+		t.Error("nil stage dropped a record")
+	}
+	for _, r := range []Rules{
+		{Exclude: []Match{{}}},
+		{Exclude: []Match{{Value: "x"}}},
+		{Include: []Match{{Message: "("}}},
+		{Include: []Match{{Label: "a", Value: "["}}},
+	} {
+		if _, err := New(r); err == nil {
+			t.Errorf("New(%+v): expected an error", r)
+		}
+	}
+}
@@ -57,3 +57,119 @@ func TestPipeline_DuckDBSource_QueryRecords(t *testing.T) {
 		t.Errorf("expected 3 patterns in store, got %d", len(list))
 	}
 }
+
+// drainAck reads n records, acknowledging each as runSource does after processing.
+func drainAck(t *testing.T, recCh <-chan types.Record, n int) []string {
+	t.Helper()
+	var msgs []string
+	timeout := time.After(5 * time.Second)
+	for len(msgs) < n {
+		select {
+		case rec, ok := <-recCh:
+			if !ok {
+				return msgs
+			}
+			if rec.Ack != nil {
+				rec.Ack()
+			}
+			msgs = append(msgs, rec.Message)
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d records: %v", len(msgs), n, msgs)
+		}
+	}
+	return msgs
+}
+
+// TestPipeline_DuckDBSource_Incremental resumes from the persisted watermark on a second run.
+func TestPipeline_DuckDBSource_Incremental(t *testing.T) {
+	db, err := duckdb.Open(filepath.Join(t.TempDir(), "src.duckdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	appendAt := func(i int, msg string) {
+		rec := types.Record{Timestamp: base.Add(time.Duration(i) * time.Second), Message: msg, SourceID: "ingest"}
+		if err := db.AppendRecord(&rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appendAt(0, "first")
+	appendAt(1, "second")
+	appendAt(2, "third")
+
+	run := func() []string {
+		wms := duckdb.NewWatermarkStore(db)
+		src := &source.DuckDBSource{DB: db.SQL(), SourceID: "history", Incremental: true, Watermarks: wms}
+		recCh, errCh := src.Stream(context.Background())
+		msgs := drainAck(t, recCh, 100)
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		if err := wms.Save(); err != nil { // as run does on shutdown
+			t.Fatal(err)
+		}
+		return msgs
+	}
+	if got := run(); len(got) != 3 || got[0] != "first" || got[2] != "third" {
+		t.Fatalf("first run: %q", got)
+	}
+	if got := run(); len(got) != 0 {
+		t.Fatalf("second run without new rows: %q", got)
+	}
+	appendAt(3, "fourth")
+	appendAt(4, "fifth")
+	if got := run(); len(got) != 2 || got[0] != "fourth" || got[1] != "fifth" {
+		t.Fatalf("third run: %q", got)
+	}
+	value, sqlType, ok := duckdb.NewWatermarkStore(db).Watermark("history")
+	if !ok || value != "2024-05-01 10:00:04" || sqlType != "TIMESTAMP" {
+		t.Errorf("watermark = %q %q %v", value, sqlType, ok)
+	}
+}
+
+// TestPipeline_DuckDBSource_IncrementalPoll polls a custom query with an id cursor column.
+func TestPipeline_DuckDBSource_IncrementalPoll(t *testing.T) {
+	db, err := duckdb.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.SQL().Exec(`CREATE TABLE events (id INTEGER, ts TIMESTAMP, sev VARCHAR, text VARCHAR)`); err != nil {
+		t.Fatal(err)
+	}
+	insert := func(id int, text string) {
+		if _, err := db.SQL().Exec(`INSERT INTO events VALUES (?, TIMESTAMP '2024-05-01 10:00:00', 'error', ?)`, id, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(1, "disk full")
+	insert(2, "disk full again")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &source.DuckDBSource{
+		DB:          db.SQL(),
+		Query:       `SELECT ts, sev, text, '{}', '', id FROM events`,
+		SourceID:    "events",
+		Incremental: true,
+		Cursor:      "id",
+		Interval:    20 * time.Millisecond,
+	}
+	recCh, _ := src.Stream(ctx)
+	if got := drainAck(t, recCh, 2); got[0] != "disk full" || got[1] != "disk full again" {
+		t.Fatalf("got %q", got)
+	}
+	insert(3, "same timestamp, new id")
+	if got := drainAck(t, recCh, 1); got[0] != "same timestamp, new id" {
+		t.Fatalf("got %q", got)
+	}
+	select {
+	case rec := <-recCh:
+		t.Errorf("unexpected re-read %+v", rec)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	for range recCh {
+	}
+}
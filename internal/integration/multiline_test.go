@@ -0,0 +1,53 @@
+package integration
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ailert/ailert/internal/engine"
+	"github.com/ailert/ailert/internal/source"
+	"github.com/ailert/ailert/internal/store"
+	"github.com/ailert/ailert/internal/testutil"
+)
+
+// TestPipeline_Multiline_StackTraceIsOnePattern checks that two occurrences of the same
+// Java stack trace become one pattern (new, then known) instead of one pattern per frame.
+func TestPipeline_Multiline_StackTraceIsOnePattern(t *testing.T) {
+	trace := []string{
+		"ERROR request 17 failed",
+		"java.lang.IllegalStateException: connection pool exhausted",
+		"\tat com.example.db.Pool.get(Pool.java:88)",
+		"\tat com.example.api.Handler.serve(Handler.java:41)",
+		"Caused by: java.util.concurrent.TimeoutException",
+		"\t... 12 more",
+	}
+	lines := append(append([]string{}, trace...), trace...)
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, lines); err != nil {
+		t.Fatal(err)
+	}
+	st := store.New("")
+	eng := engine.New(st)
+	src := &source.FileSource{Path: logPath, SourceID: "test", Multiline: &source.MultilineRule{}}
+	recCh, errCh := src.Stream(context.Background())
+	go func() {
+		for range errCh {
+		}
+	}()
+	var newCount, knownCount int
+	for rec := range recCh {
+		if res := eng.Process(&rec); res.IsNew {
+			newCount++
+		} else {
+			knownCount++
+		}
+	}
+	if newCount != 1 || knownCount != 1 {
+		t.Errorf("new=%d known=%d, want 1 and 1", newCount, knownCount)
+	}
+	if n := len(st.ListSeen()); n != 1 {
+		t.Errorf("patterns in store = %d, want 1", n)
+	}
+}
@@ -10,7 +10,8 @@ import (
 	"github.com/ailert/ailert/internal/testutil"
 )
 
-// TestPipeline_PrometheusSource_ScrapeAndPatterns scrapes a /metrics endpoint and runs pattern detection.
+// TestPipeline_PrometheusSource_ScrapeAndPatterns scrapes a /metrics endpoint and runs pattern detection
+// on the series events (the first scrape reports every series as new).
 func TestPipeline_PrometheusSource_ScrapeAndPatterns(t *testing.T) {
 	srv := testutil.NewMetricsServer(testutil.SamplePrometheusMetrics())
 	defer srv.Close()
@@ -29,9 +30,9 @@ func TestPipeline_PrometheusSource_ScrapeAndPatterns(t *testing.T) {
 		_ = eng.Process(&rec)
 		count++
 	}
-	// Sample has 3 data lines (2 with labels, 1 without)
+	// Sample has 3 series (2 with labels, 1 without)
 	if count < 3 {
-		t.Errorf("expected at least 3 metric lines, got %d", count)
+		t.Errorf("expected at least 3 new series events, got %d", count)
 	}
 	list := st.ListSeen()
 	if len(list) == 0 {
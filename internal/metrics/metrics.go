@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -10,12 +11,30 @@ import (
 // Counters for pipeline observability. Optional; zero if not used.
 var (
 	RecordsProcessed atomic.Int64
+	RecordsDropped   atomic.Int64
 	PatternsNew      atomic.Int64
 	PatternsKnown    atomic.Int64
 	PatternsSuppressed atomic.Int64
 	AlertsEmitted    atomic.Int64
 )
 
+// sourceErrors counts failed fetches/reads per source ID (*atomic.Int64 values).
+var sourceErrors sync.Map
+
+// SourceError records one error for the source with the given ID.
+func SourceError(sourceID string) {
+	c, _ := sourceErrors.LoadOrStore(sourceID, new(atomic.Int64))
+	c.(*atomic.Int64).Add(1)
+}
+
+// SourceErrors returns the number of errors recorded for the source.
+func SourceErrors(sourceID string) int64 {
+	if c, ok := sourceErrors.Load(sourceID); ok {
+		return c.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
 // Handler returns an http.Handler that serves Prometheus text exposition for the counters.
 // Serves on the given mux or nil to use http.DefaultServeMux.
 func Handler(mux *http.ServeMux) http.Handler {
@@ -31,6 +50,9 @@ func Handler(mux *http.ServeMux) http.Handler {
 		w.Write([]byte("# HELP ailert_records_processed_total Records processed by the pipeline\n"))
 		w.Write([]byte("# TYPE ailert_records_processed_total counter\n"))
 		w.Write([]byte("ailert_records_processed_total " + strconv.FormatInt(RecordsProcessed.Load(), 10) + "\n"))
+		w.Write([]byte("# HELP ailert_records_dropped_total Records dropped by source include/exclude filters\n"))
+		w.Write([]byte("# TYPE ailert_records_dropped_total counter\n"))
+		w.Write([]byte("ailert_records_dropped_total " + strconv.FormatInt(RecordsDropped.Load(), 10) + "\n"))
 		w.Write([]byte("# HELP ailert_patterns_new_total Patterns seen for the first time\n"))
 		w.Write([]byte("# TYPE ailert_patterns_new_total counter\n"))
 		w.Write([]byte("ailert_patterns_new_total " + strconv.FormatInt(PatternsNew.Load(), 10) + "\n"))
@@ -43,6 +65,17 @@ func Handler(mux *http.ServeMux) http.Handler {
 		w.Write([]byte("# HELP ailert_alerts_emitted_total Alerts sent to Alertmanager\n"))
 		w.Write([]byte("# TYPE ailert_alerts_emitted_total counter\n"))
 		w.Write([]byte("ailert_alerts_emitted_total " + strconv.FormatInt(AlertsEmitted.Load(), 10) + "\n"))
+		w.Write([]byte("# HELP ailert_source_errors_total Source fetch/read errors by source\n"))
+		w.Write([]byte("# TYPE ailert_source_errors_total counter\n"))
+		var ids []string
+		sourceErrors.Range(func(k, _ any) bool {
+			ids = append(ids, k.(string))
+			return true
+		})
+		sort.Strings(ids)
+		for _, id := range ids {
+			w.Write([]byte("ailert_source_errors_total{source=" + strconv.Quote(id) + "} " + strconv.FormatInt(SourceErrors(id), 10) + "\n"))
+		}
 	})
 }
 
@@ -0,0 +1,349 @@
+package pattern
+
+import (
+	"strings"
+	"sync"
+)
+
+// Drain parse tree defaults.
+const (
+	// DefaultSimilarity is the share of positions whose tokens must be equal for a pattern
+	// to join an existing cluster.
+	DefaultSimilarity = 0.7
+	// DefaultDepth is how many leading tokens route a pattern to its leaf.
+	DefaultDepth = 2
+	// DefaultMaxChildren bounds the children of a tree node; further distinct tokens at
+	// that position share the Wildcard child.
+	DefaultMaxChildren = 100
+)
+
+// Cluster is a group of patterns that matched each other. Its ID is the hash of the first
+// pattern and stays the same while the cluster's template is generalized: a position where
+// the patterns differ becomes Wildcard.
+type Cluster struct {
+	ID string
+
+	seq    int      // creation order
+	words  []string // constant words, Wildcard where the cluster's patterns differ
+	tokens []string // template tokens
+	key    string   // words with runs of Wildcard collapsed into one
+	merged *Cluster // cluster this one was merged into, if any
+}
+
+// Result is the outcome of Tree.Match.
+type Result struct {
+	ID       string   // stable cluster ID
+	Template string   // current template of the cluster
+	Added    bool     // the cluster was created by this call
+	Changed  bool     // the template was generalized by this call
+	Merged   []string // IDs of clusters merged into ID by this call
+	Words    []string // current words of the cluster, to restore it with Tree.Add
+}
+
+// Tree is a Drain-style fixed-depth parse tree: patterns are routed by token count and then
+// by their first Depth tokens to a small leaf, and only the clusters in that leaf are
+// compared. Matching cost is therefore independent of the total number of clusters.
+// Leading tokens that had digits in them ("a1b2c") are taken for variables and routed, and
+// stored in the cluster, as Wildcard. A variable plain word among the first Depth tokens
+// ("user alice logged in", "user bob logged in") does route lines to different leaves, so
+// each value gets its own cluster; lower Depth when such lines should be one pattern.
+//
+// When a cluster's words generalize so that, with runs of wildcards collapsed, they equal
+// those of a cluster of another length ("user <*> logged in" and "user <*> <*> logged in"),
+// the younger cluster is merged into the older one; lines routed to either report the older
+// cluster's ID from then on. Safe for concurrent use.
+type Tree struct {
+	Similarity  float64 // 0 means DefaultSimilarity
+	Depth       int     // 0 means DefaultDepth
+	MaxChildren int     // 0 means DefaultMaxChildren
+
+	mu    sync.RWMutex
+	root  map[int]*node       // by token count
+	byKey map[string]*Cluster // live (not merged) clusters by key
+	seq   int
+	count int
+}
+
+type node struct {
+	children map[string]*node
+	clusters []*Cluster
+}
+
+// Len returns the number of live clusters in the tree; merged clusters are not counted.
+func (t *Tree) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.count
+}
+
+// Match returns the cluster p belongs to, adding a new cluster when none is similar enough
+// and generalizing the cluster where p differs from it.
+func (t *Tree) Match(p *Pattern) Result {
+	t.mu.RLock()
+	c := t.search(p)
+	if c != nil && !c.differs(p) {
+		r := c.live().result()
+		t.mu.RUnlock()
+		return r
+	}
+	t.mu.RUnlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c = t.search(p); c == nil {
+		r := t.insert(p.Hash(), t.route(p), p.tokens).result()
+		r.Added = true
+		return r
+	}
+	wordsChanged, tokensChanged := c.generalize(p)
+	if c.merged != nil {
+		// Only the cluster c was merged into is reported; its template is unaffected.
+		return c.live().result()
+	}
+	var merged []string
+	if wordsChanged {
+		merged = t.rekey(c)
+	}
+	r := c.live().result()
+	r.Changed = tokensChanged && c.merged == nil
+	r.Merged = merged
+	return r
+}
+
+// Add inserts a cluster with a known ID, template and words (Result.Words), e.g. one
+// restored from a pattern store, so that matching lines keep reporting that ID. p, the
+// cluster's first pattern, supplies whichever of template and words is empty.
+func (t *Tree) Add(p *Pattern, id, template string, words []string) {
+	tokens := p.tokens
+	if template != "" {
+		tokens = strings.Split(template, " ")
+	}
+	if words == nil {
+		words = t.route(p)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.insert(id, words, tokens)
+}
+
+func (t *Tree) insert(id string, words, tokens []string) *Cluster {
+	t.seq++
+	c := &Cluster{
+		ID:     id,
+		seq:    t.seq,
+		words:  append([]string(nil), words...),
+		tokens: append([]string(nil), tokens...),
+	}
+	c.key = clusterKey(c.words)
+	leaf := t.leaf(c.words, true)
+	leaf.clusters = append(leaf.clusters, c)
+	t.count++
+	if t.byKey == nil {
+		t.byKey = make(map[string]*Cluster)
+	}
+	if t.byKey[c.key] == nil {
+		t.byKey[c.key] = c
+	}
+	return c
+}
+
+// rekey updates the key of the live cluster c after its words changed. If another live
+// cluster has the new key, the younger of the two is merged into the older, whose ID alerts
+// and suppressions already refer to; the merged ID is returned.
+func (t *Tree) rekey(c *Cluster) []string {
+	if t.byKey[c.key] == c {
+		delete(t.byKey, c.key)
+	}
+	c.key = clusterKey(c.words)
+	other := t.byKey[c.key]
+	if other == nil {
+		t.byKey[c.key] = c
+		return nil
+	}
+	keep, drop := other, c
+	if c.seq < other.seq {
+		keep, drop = c, other
+	}
+	drop.merged = keep
+	t.byKey[c.key] = keep
+	t.count--
+	return []string{drop.ID}
+}
+
+// search returns the most similar cluster at or above the threshold, or nil.
+func (t *Tree) search(p *Pattern) *Cluster {
+	leaf := t.leaf(t.route(p), false)
+	if leaf == nil {
+		return nil
+	}
+	var best *Cluster
+	bestSim := -1.0
+	for _, c := range leaf.clusters {
+		if sim := similarity(c.words, p.words); sim > bestSim {
+			best, bestSim = c, sim
+		}
+	}
+	if best == nil || bestSim < t.similarity() {
+		return nil
+	}
+	return best
+}
+
+// route returns the words of p that lead to its leaf: the words, with those among the first
+// Depth that had digits replaced by Wildcard.
+func (t *Tree) route(p *Pattern) []string {
+	var words []string // copied on the first replacement
+	for i := 0; i < t.depth() && i < len(p.words); i++ {
+		if p.digits[i] {
+			if words == nil {
+				words = append([]string(nil), p.words...)
+			}
+			words[i] = Wildcard
+		}
+	}
+	if words == nil {
+		return p.words
+	}
+	return words
+}
+
+// leaf walks (and with create, builds) the path of words: their count, then the leading
+// words.
+func (t *Tree) leaf(words []string, create bool) *node {
+	if t.root == nil {
+		if !create {
+			return nil
+		}
+		t.root = make(map[int]*node)
+	}
+	n := t.root[len(words)]
+	if n == nil {
+		if !create {
+			return nil
+		}
+		n = &node{}
+		t.root[len(words)] = n
+	}
+	for i := 0; i < t.depth() && i < len(words); i++ {
+		tok := words[i]
+		child := n.children[tok]
+		if child == nil {
+			child = n.children[Wildcard]
+		}
+		if child == nil {
+			if !create {
+				return nil
+			}
+			if n.children == nil {
+				n.children = make(map[string]*node)
+			}
+			if len(n.children) >= t.maxChildren()-1 {
+				tok = Wildcard // keep the last slot for the wildcard child
+			}
+			child = &node{}
+			n.children[tok] = child
+		}
+		n = child
+	}
+	return n
+}
+
+func (t *Tree) similarity() float64 {
+	if t.Similarity > 0 {
+		return t.Similarity
+	}
+	return DefaultSimilarity
+}
+
+func (t *Tree) depth() int {
+	if t.Depth > 0 {
+		return t.Depth
+	}
+	return DefaultDepth
+}
+
+func (t *Tree) maxChildren() int {
+	if t.MaxChildren > 1 {
+		return t.MaxChildren
+	}
+	return DefaultMaxChildren
+}
+
+// live follows merges to the cluster c now belongs to.
+func (c *Cluster) live() *Cluster {
+	for c.merged != nil {
+		c = c.merged
+	}
+	return c
+}
+
+func (c *Cluster) result() Result {
+	return Result{ID: c.ID, Template: strings.Join(c.tokens, " "), Words: append([]string(nil), c.words...)}
+}
+
+// differs reports whether matching p would generalize c.
+func (c *Cluster) differs(p *Pattern) bool {
+	for i, w := range p.words {
+		if c.words[i] != w && c.words[i] != Wildcard {
+			return true
+		}
+	}
+	if len(c.tokens) == len(p.tokens) {
+		for i, tok := range p.tokens {
+			if c.tokens[i] != tok && c.tokens[i] != Wildcard {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// generalize turns every word and template token where p differs from c into Wildcard.
+// Template tokens are only compared when both have the same number of tokens.
+func (c *Cluster) generalize(p *Pattern) (words, tokens bool) {
+	for i, w := range p.words {
+		if c.words[i] != w && c.words[i] != Wildcard {
+			c.words[i] = Wildcard
+			words = true
+		}
+	}
+	if len(c.tokens) == len(p.tokens) {
+		for i, tok := range p.tokens {
+			if c.tokens[i] != tok && c.tokens[i] != Wildcard {
+				c.tokens[i] = Wildcard
+				tokens = true
+			}
+		}
+	}
+	return words, tokens
+}
+
+// clusterKey joins words, collapsing runs of Wildcard into one.
+func clusterKey(words []string) string {
+	var b strings.Builder
+	for i, w := range words {
+		if w == Wildcard && i > 0 && words[i-1] == Wildcard {
+			continue
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(w)
+	}
+	return b.String()
+}
+
+// similarity is the share of positions where two equally long token lists agree; a Wildcard
+// in the cluster's list matches any token. Two empty lists are identical.
+func similarity(cluster, words []string) float64 {
+	if len(cluster) == 0 {
+		return 1
+	}
+	same := 0
+	for i := range cluster {
+		if cluster[i] == words[i] || cluster[i] == Wildcard {
+			same++
+		}
+	}
+	return float64(same) / float64(len(cluster))
+}
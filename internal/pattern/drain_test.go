@@ -0,0 +1,241 @@
+package pattern
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTree_Match(t *testing.T) {
+	tr := &Tree{}
+	r1 := tr.Match(New("ERROR connection refused from db primary"))
+	if !r1.Added || r1.ID != New("ERROR connection refused from db primary").Hash() {
+		t.Fatalf("first match: %+v", r1)
+	}
+	// One of five tokens differs: similarity 0.8 joins the cluster.
+	if r2 := tr.Match(New("ERROR connection refused from db replica")); r2.Added || r2.ID != r1.ID {
+		t.Errorf("similar line got its own cluster: %+v", r2)
+	}
+	// Same length, two tokens differ: 0.6 is below the default threshold.
+	if r3 := tr.Match(New("ERROR connection reset by db replica")); !r3.Added || r3.ID == r1.ID {
+		t.Errorf("different line joined the cluster")
+	}
+	// Different token count never matches.
+	if r := tr.Match(New("ERROR connection refused from db")); !r.Added {
+		t.Error("shorter line joined the cluster")
+	}
+	if tr.Len() != 3 {
+		t.Errorf("Len = %d, want 3", tr.Len())
+	}
+}
+
+func TestTree_Similarity(t *testing.T) {
+	tr := &Tree{Similarity: 0.5}
+	r1 := tr.Match(New("user login from alice"))
+	if r2 := tr.Match(New("user login by bob")); r2.Added || r2.ID != r1.ID {
+		t.Error("with threshold 0.5 two of four equal tokens should match")
+	}
+	strict := &Tree{Similarity: 1}
+	strict.Match(New("user alice logged in"))
+	if r := strict.Match(New("user bob logged in")); !r.Added {
+		t.Error("with threshold 1 any difference should make a new cluster")
+	}
+}
+
+func TestTree_RoutesByLeadingTokens(t *testing.T) {
+	tr := &Tree{Similarity: 0.5}
+	tr.Match(New("alpha beta gamma delta"))
+	// Similar enough, but the first token differs, so it lands in another leaf.
+	if r := tr.Match(New("omega beta gamma delta")); !r.Added {
+		t.Error("line with a different leading token should not be compared")
+	}
+}
+
+func TestTree_LeadingVariable(t *testing.T) {
+	tr := &Tree{}
+	// A leading token with digits is routed as a wildcard, so ids share a cluster.
+	r1 := tr.Match(New("job q1r2s started ok"))
+	r2 := tr.Match(New("job t9u8v started ok"))
+	if r2.Added || r2.ID != r1.ID || r2.Template != "job <*> started ok" {
+		t.Errorf("digit token in the routing words: %+v, want cluster %s", r2, r1.ID)
+	}
+	// A plain word there routes to another leaf: each user is a pattern of its own...
+	alice := tr.Match(New("user alice logged in from 10.0.0.1"))
+	bob := tr.Match(New("user bob logged in from 10.0.0.2"))
+	if !bob.Added || bob.ID == alice.ID {
+		t.Errorf("word variable in the routing words joined: %+v", bob)
+	}
+	// ...unless Depth leaves it out of the route.
+	shallow := &Tree{Depth: 1}
+	alice = shallow.Match(New("user alice logged in from 10.0.0.1"))
+	if bob := shallow.Match(New("user bob logged in from 10.0.0.2")); bob.ID != alice.ID {
+		t.Errorf("Depth 1: %+v, want cluster %s", bob, alice.ID)
+	}
+}
+
+func TestTree_MaxChildren(t *testing.T) {
+	tr := &Tree{MaxChildren: 3, Depth: 1, Similarity: 0.6}
+	ids := map[string]string{}
+	for _, w := range []string{"alpha", "beta", "gamma", "delta"} {
+		ids[w] = tr.Match(New(w + " request failed")).ID
+	}
+	// alpha and beta got nodes; gamma and delta share the wildcard child, where
+	// "epsilon request failed" matches the gamma cluster (two of three tokens).
+	if r := tr.Match(New("epsilon request failed")); r.Added || r.ID != ids["gamma"] {
+		t.Errorf("overflow token: %+v, want cluster %s", r, ids["gamma"])
+	}
+}
+
+func TestTree_Generalize(t *testing.T) {
+	tr := &Tree{}
+	r1 := tr.Match(New("ERROR sync failed for user alice code=7"))
+	if r1.Template != "ERROR sync failed for user alice code=<NUM>" {
+		t.Errorf("template = %q", r1.Template)
+	}
+	r2 := tr.Match(New("ERROR sync failed for user bob code=9"))
+	if r2.ID != r1.ID || !r2.Changed || r2.Template != "ERROR sync failed for user <*> code=<NUM>" {
+		t.Errorf("second line: %+v", r2)
+	}
+	if r3 := tr.Match(New("ERROR sync failed for user carol code=1")); r3.ID != r1.ID || r3.Changed || r3.Template != r2.Template {
+		t.Errorf("third line: %+v", r3)
+	}
+	// The generalized position matches any word: without that only 4 of 6 words (0.67)
+	// would agree.
+	if r := tr.Match(New("ERROR sync aborted for user eve code=3")); r.ID != r1.ID || r.Template != "ERROR sync <*> for user <*> code=<NUM>" {
+		t.Errorf("line matching via the wildcard: %+v", r)
+	}
+}
+
+func TestTree_MergeAcrossLengths(t *testing.T) {
+	tr := &Tree{}
+	short := tr.Match(New("ERROR sync failed for user alice smith"))
+	tr.Match(New("ERROR sync failed for user bob smith"))
+	long := tr.Match(New("ERROR sync failed for user mary ann jones"))
+	if !long.Added || tr.Len() != 2 {
+		t.Fatalf("longer line: %+v, Len = %d", long, tr.Len())
+	}
+	// The long cluster generalizes to "... user <*> <*> jones"; not yet equal to
+	// "... user <*> smith".
+	if r := tr.Match(New("ERROR sync failed for user jo bee jones")); r.ID != long.ID || len(r.Merged) != 0 {
+		t.Fatalf("no merge expected yet: %+v", r)
+	}
+	// Both end in a wildcard now: "... user <*>" once runs of wildcards are collapsed.
+	tr.Match(New("ERROR sync failed for user carol white"))
+	r := tr.Match(New("ERROR sync failed for user jo bee brown"))
+	if r.ID != short.ID || len(r.Merged) != 1 || r.Merged[0] != long.ID {
+		t.Fatalf("merge: %+v, want ID %s merged %s", r, short.ID, long.ID)
+	}
+	if tr.Len() != 1 {
+		t.Errorf("Len = %d, want 1", tr.Len())
+	}
+	// Lines routed to the merged cluster report the surviving ID.
+	if r := tr.Match(New("ERROR sync failed for user li na wu")); r.ID != short.ID || len(r.Merged) != 0 {
+		t.Errorf("after merge: %+v", r)
+	}
+}
+
+func TestTree_Add(t *testing.T) {
+	tr := &Tree{}
+	tr.Add(New("ERROR disk sda full"), "stored-id", "ERROR disk <*> full", nil)
+	r := tr.Match(New("ERROR disk sdb full"))
+	if r.Added || r.ID != "stored-id" || r.Template != "ERROR disk <*> full" {
+		t.Errorf("match after Add: %+v", r)
+	}
+}
+
+func TestTree_Concurrent(t *testing.T) {
+	tr := &Tree{}
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for g := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				r := tr.Match(New(fmt.Sprintf("ERROR worker %s failed job", word(i%10))))
+				if i == 0 {
+					ids[g] = r.ID
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if tr.Len() != 1 {
+		t.Errorf("Len = %d, want 1 (worker name is the only variable token)", tr.Len())
+	}
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Errorf("goroutines saw different clusters: %v", ids)
+			break
+		}
+	}
+}
+
+// word returns a distinct lowercase word for i (digits would be stripped by New).
+func word(i int) string {
+	var b strings.Builder
+	b.WriteString("w")
+	for {
+		b.WriteByte(byte('a' + i%26))
+		i /= 26
+		if i == 0 {
+			break
+		}
+	}
+	b.WriteString("x")
+	return b.String()
+}
+
+// distinctPatterns builds n templates that do not match each other: they vary in length and
+// in several positions.
+func distinctPatterns(n int) []*Pattern {
+	verbs := []string{"failed", "started", "stopped", "timeout", "refused", "retry", "degraded"}
+	out := make([]*Pattern, n)
+	for i := range out {
+		extra := strings.Repeat(" detail", i%5)
+		out[i] = New(fmt.Sprintf("%s service %s %s on %s node%s", word(i%97), word(i), verbs[i%len(verbs)], word(i/7), extra))
+	}
+	return out
+}
+
+// linear is the matching the engine used before the parse tree: a scan over every pattern
+// with WeakEqual.
+type linear struct{ patterns []*Pattern }
+
+func (l *linear) match(p *Pattern) string {
+	for _, q := range l.patterns {
+		if q.WeakEqual(p) {
+			return q.Hash()
+		}
+	}
+	l.patterns = append(l.patterns, p)
+	return p.Hash()
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range []int{1000, 10000, 50000} {
+		pats := distinctPatterns(n)
+		lines := make([]*Pattern, 1000)
+		for i := range lines {
+			lines[i] = pats[(i*7919)%n]
+		}
+		b.Run(fmt.Sprintf("tree/%d", n), func(b *testing.B) {
+			tr := &Tree{}
+			for _, p := range pats {
+				tr.Match(p)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tr.Match(lines[i%len(lines)])
+			}
+		})
+		b.Run(fmt.Sprintf("linear/%d", n), func(b *testing.B) {
+			l := &linear{patterns: pats} // distinct by construction
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				l.match(lines[i%len(lines)])
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package pattern
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Built-in mask types for MaskRule.Type.
+const (
+	MaskIP       = "ip" // IPv4 (with optional port) and IPv6
+	MaskIPv4     = "ipv4"
+	MaskIPv6     = "ipv6"
+	MaskEmail    = "email"
+	MaskURL      = "url"
+	MaskPath     = "path"     // absolute Unix paths
+	MaskDuration = "duration" // 150ms, 1.5s, 2h30m
+	MaskK8sPod   = "k8s_pod"  // pod names generated by Deployments, ReplicaSets, DaemonSets and Jobs
+	MaskRegex    = "regex"
+)
+
+// MaskRule replaces matches in a line with a placeholder before the pattern is built, so
+// identifiers the built-in detection keeps as words (pod names, order ids) do not make every
+// line a new pattern. Type is a built-in mask or "regex" (the default when Regex is set).
+// Name sets the placeholder, <NAME>; it defaults to the type (<IP>, <EMAIL>, <URL>, <PATH>,
+// <DURATION>, <POD>) and is required for regex rules. When Regex has a capture group, only
+// the first group is masked.
+type MaskRule struct {
+	Type  string `yaml:"type"`
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// Masker applies an ordered list of mask rules: where matches overlap, the earlier rule
+// wins. A nil Masker masks nothing.
+type Masker struct {
+	rules []mask
+}
+
+type mask struct {
+	re    *regexp.Regexp
+	ph    string
+	valid func(string) bool
+}
+
+const podAlphabet = "bcdfghjklmnpqrstvwxz2456789" // characters Kubernetes uses in generated names
+
+var builtinMasks = map[string]mask{
+	MaskIPv4:     {re: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d{1,5})?\b`), ph: IP, valid: validIPv4},
+	MaskIPv6:     {re: regexp.MustCompile(`(?i)(?:[0-9a-f]{0,4}:){2,7}[0-9a-f]{0,4}`), ph: IP, valid: validIPv6},
+	MaskEmail:    {re: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`), ph: "<EMAIL>"},
+	MaskURL:      {re: regexp.MustCompile(`\b[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`), ph: "<URL>"},
+	MaskPath:     {re: regexp.MustCompile(`(?:^|[\s=("'])((?:/[\w.@~+-]+)+/?)`), ph: "<PATH>"},
+	MaskDuration: {re: regexp.MustCompile(`\b(?:\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h|d))+\b`), ph: "<DURATION>"},
+	MaskK8sPod: {
+		re:    regexp.MustCompile(`\b[a-z0-9](?:[a-z0-9.-]*[a-z0-9])?(?:-[` + podAlphabet + `]{6,10})?-[` + podAlphabet + `]{5}\b`),
+		ph:    "<POD>",
+		valid: validPod,
+	},
+}
+
+// NewMasker compiles rules. It returns nil when there are none.
+func NewMasker(rules []MaskRule) (*Masker, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	m := &Masker{}
+	for i, r := range rules {
+		typ := r.Type
+		if typ == "" && r.Regex != "" {
+			typ = MaskRegex
+		}
+		var ms []mask
+		switch typ {
+		case MaskRegex:
+			if r.Regex == "" || r.Name == "" {
+				return nil, fmt.Errorf("masks[%d]: regex rules need regex and name", i)
+			}
+			re, err := regexp.Compile(r.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("masks[%d]: %w", i, err)
+			}
+			ms = []mask{{re: re}}
+		case MaskIP:
+			ms = []mask{builtinMasks[MaskIPv4], builtinMasks[MaskIPv6]}
+		default:
+			b, ok := builtinMasks[typ]
+			if !ok {
+				return nil, fmt.Errorf("masks[%d]: unknown type %q", i, r.Type)
+			}
+			ms = []mask{b}
+		}
+		for _, mk := range ms {
+			if r.Name != "" {
+				mk.ph = "<" + strings.ToUpper(r.Name) + ">"
+			}
+			m.rules = append(m.rules, mk)
+		}
+	}
+	return m, nil
+}
+
+// MaskFingerprint identifies a list of mask rules, which change the hashes the rules apply
+// to, so patterns can be stored with the rules they were hashed with (see HashVersion). It
+// is "" when there are no rules.
+func MaskFingerprint(rules []MaskRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, r := range rules {
+		typ := r.Type
+		if typ == "" && r.Regex != "" {
+			typ = MaskRegex
+		}
+		fmt.Fprintf(&b, "%s\x00%s\x00%s\x00", typ, strings.ToUpper(r.Name), r.Regex)
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(b.String())))
+}
+
+// span is a masked part of a line: [start, end) in the original line, or, after apply, the
+// position of its placeholder in the masked line.
+type span struct {
+	start, end int
+	ph         string
+	val        string
+}
+
+// apply returns line with every match replaced by its placeholder, and the masked spans in
+// line order.
+func (m *Masker) apply(line string) (string, []span) {
+	if m == nil {
+		return line, nil
+	}
+	var spans []span
+	for _, r := range m.rules {
+		for _, loc := range r.re.FindAllStringSubmatchIndex(line, -1) {
+			start, end := loc[0], loc[1]
+			if len(loc) > 2 && loc[2] >= 0 {
+				start, end = loc[2], loc[3]
+			}
+			if start == end || overlaps(spans, start, end) {
+				continue
+			}
+			if r.valid != nil && !r.valid(line[start:end]) {
+				continue
+			}
+			spans = append(spans, span{start: start, end: end, ph: r.ph, val: line[start:end]})
+		}
+	}
+	if len(spans) == 0 {
+		return line, nil
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	var b strings.Builder
+	last := 0
+	for i, s := range spans {
+		b.WriteString(line[last:s.start])
+		spans[i].start = b.Len()
+		b.WriteString(s.ph)
+		spans[i].end = b.Len()
+		last = s.end
+	}
+	b.WriteString(line[last:])
+	return b.String(), spans
+}
+
+func overlaps(spans []span, start, end int) bool {
+	for _, s := range spans {
+		if start < s.end && s.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+func validIPv4(s string) bool {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(s) != nil
+}
+
+func validIPv6(s string) bool {
+	return strings.Count(s, ":") >= 2 && net.ParseIP(s) != nil
+}
+
+// validPod requires a digit in the generated suffix, so hyphenated words such as
+// "read-shards" are not taken for pod names.
+func validPod(s string) bool {
+	parts := strings.Split(s, "-")
+	suffix := parts[len(parts)-1]
+	if n := len(parts); n > 2 && len(parts[n-2]) >= 6 && strings.Trim(parts[n-2], podAlphabet) == "" {
+		suffix = parts[n-2] + suffix
+	}
+	return strings.ContainsAny(suffix, "0123456789")
+}
@@ -0,0 +1,108 @@
+package pattern
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewMasked(t *testing.T) {
+	m, err := NewMasker([]MaskRule{
+		{Type: MaskURL},
+		{Type: MaskEmail},
+		{Type: MaskIP},
+		{Type: MaskPath},
+		{Type: MaskDuration},
+		{Type: MaskK8sPod},
+		{Name: "order", Regex: `order=(ORD-[A-Z]+)`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		line   string
+		tmpl   string
+		params []string
+	}{
+		{"GET https://api.example.com/v1/users?id=7 from 10.0.0.7:443", "GET <URL> from <IP>", []string{"https://api.example.com/v1/users?id=7", "10.0.0.7:443"}},
+		{"mail to jane.doe@example.com bounced", "mail to <EMAIL> bounced", []string{"jane.doe@example.com"}},
+		{"dial [2001:db8::1]:53 timed out after 1m30s", "dial [<IP>]:53 timed out after <DURATION>", []string{"2001:db8::1", "1m30s"}},
+		{"open /var/lib/app/data.db failed (attempt 2)", "open <PATH> failed <*>", []string{"/var/lib/app/data.db", "attempt 2"}},
+		{"pod api-7d9f8b6c5-xk2lp OOMKilled", "pod <POD> OOMKilled", []string{"api-7d9f8b6c5-xk2lp"}},
+		{"probe failed for node-exporter-4xq7z", "probe failed for <POD>", []string{"node-exporter-4xq7z"}},
+		{"cache read-shards warmed", "cache read-shards warmed", nil},
+		{"payment order=ORD-QWERTY declined code=51", "payment order=<ORDER> declined code=<NUM>", []string{"ORD-QWERTY", "51"}},
+	}
+	for _, tt := range tests {
+		p := NewMasked(tt.line, m)
+		if p.String() != tt.tmpl || !reflect.DeepEqual(p.Params(), tt.params) {
+			t.Errorf("NewMasked(%q) => %q %q, want %q %q", tt.line, p.String(), p.Params(), tt.tmpl, tt.params)
+		}
+	}
+	// Masked identifiers do not change the hash.
+	a := NewMasked("pod api-7d9f8b6c5-xk2lp OOMKilled", m)
+	b := NewMasked("pod worker-5c8d7b9f4-mq2vz OOMKilled", m)
+	if a.Hash() != b.Hash() {
+		t.Error("lines differing only in pod name got different hashes")
+	}
+	if New("pod api-7d9f8b6c5-xk2lp OOMKilled").Hash() == New("pod worker-5c8d7b9f4-mq2vz OOMKilled").Hash() {
+		t.Error("without masks pod names should still be words")
+	}
+}
+
+func TestNewMasker_Order(t *testing.T) {
+	internal := MaskRule{Name: "internal", Regex: `\b10\.\d+\.\d+\.\d+\b`}
+	for _, tt := range []struct {
+		rules []MaskRule
+		want  string
+	}{
+		{[]MaskRule{{Type: MaskIP}, internal}, "from <IP> via <IP>"},
+		{[]MaskRule{internal, {Type: MaskIP}}, "from <INTERNAL> via <IP>"},
+		{[]MaskRule{{Type: MaskIP, Name: "addr"}}, "from <ADDR> via <ADDR>"},
+	} {
+		m, err := NewMasker(tt.rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := NewMasked("from 10.1.2.3 via 192.168.0.1", m); p.String() != tt.want {
+			t.Errorf("rules %+v: template = %q, want %q", tt.rules, p.String(), tt.want)
+		}
+	}
+}
+
+func TestNewMasker_Errors(t *testing.T) {
+	if m, err := NewMasker(nil); m != nil || err != nil {
+		t.Errorf("NewMasker(nil) = %v, %v", m, err)
+	}
+	for _, r := range []MaskRule{
+		{Type: "phone"},
+		{Regex: `\d+`},
+		{Name: "x", Regex: `(`},
+		{Type: MaskRegex, Name: "x"},
+	} {
+		if _, err := NewMasker([]MaskRule{r}); err == nil {
+			t.Errorf("NewMasker(%+v): expected an error", r)
+		}
+	}
+}
+
+func TestMaskFingerprint(t *testing.T) {
+	pod := []MaskRule{{Type: MaskK8sPod}}
+	if MaskFingerprint(nil) != "" {
+		t.Error("no rules should have an empty fingerprint")
+	}
+	if MaskFingerprint(pod) != MaskFingerprint([]MaskRule{{Type: MaskK8sPod}}) {
+		t.Error("fingerprint is not stable")
+	}
+	if MaskFingerprint([]MaskRule{{Regex: `id=(\w+)`, Name: "id"}}) != MaskFingerprint([]MaskRule{{Type: MaskRegex, Regex: `id=(\w+)`, Name: "ID"}}) {
+		t.Error("defaulted type and placeholder case should not matter")
+	}
+	for _, other := range [][]MaskRule{
+		{{Type: MaskK8sPod, Name: "pod_name"}},
+		{{Type: MaskK8sPod}, {Type: MaskIP}},
+		{{Type: MaskIP}, {Type: MaskK8sPod}},
+	} {
+		if MaskFingerprint(other) == MaskFingerprint(pod) {
+			t.Errorf("%+v has the fingerprint of %+v", other, pod)
+		}
+	}
+}
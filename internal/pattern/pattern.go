@@ -10,20 +10,39 @@ import (
 
 const minWordLen = 2
 
+// HashVersion identifies the hashing scheme of Pattern.Hash. Bump it with any change to the
+// normalization in New that can change hashes, so stored patterns can be recognized as stale
+// and migrated (ailert migrate). Version 1 is the MD5 of the constant words joined by
+// spaces. Mask rules change hashes too; MaskFingerprint tells those apart.
+const HashVersion = 1
+
 var (
 	hex  = regexp.MustCompile(`^[a-fA-F0-9]{4,}$`)
 	uuid = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$`)
 )
 
-// Pattern represents a normalized log template (variable parts removed).
+// Pattern represents a normalized log template. The hash covers only the constant words of
+// the line (numbers, hex, UUIDs and quoted or bracketed text dropped); the template keeps
+// every token and shows where the variables were.
 type Pattern struct {
-	words []string
-	str   string
-	hash  string
+	words  []string
+	digits []bool   // words[i] had digits removed, so it is likely a variable
+	tokens []string // template tokens
+	str    string
+	params []string
+	hash   string
 }
 
-// New builds a pattern from a log line: tokenize, drop numbers/hex/uuid, join to template.
+// New builds a pattern from a log line: tokenize, drop numbers/hex/uuid, join and hash the
+// remaining words, and render the template with placeholders.
 func New(line string) *Pattern {
+	return NewMasked(line, nil)
+}
+
+// NewMasked is New with the mask rules of m applied to the line first. Masked values become
+// placeholders in the template and parameters, and are left out of the hash.
+func NewMasked(line string, m *Masker) *Pattern {
+	line, masked := m.apply(line)
 	p := &Pattern{}
 	for _, w := range strings.Fields(removeQuotedAndBrackets(line)) {
 		w = strings.TrimRight(w, "=:],;")
@@ -33,20 +52,25 @@ func New(line string) *Pattern {
 		if hex.MatchString(w) || uuid.MatchString(w) {
 			continue
 		}
-		w = removeDigits(w)
-		if isWord(w) {
-			p.words = append(p.words, w)
+		d := removeDigits(w)
+		if isWord(d) {
+			p.words = append(p.words, d)
+			p.digits = append(p.digits, d != w)
 		}
 	}
-	p.str = strings.Join(p.words, " ")
-	p.hash = fmt.Sprintf("%x", md5.Sum([]byte(p.str)))
+	p.hash = fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(p.words, " "))))
+	p.tokens, p.params = template(line, masked)
+	p.str = strings.Join(p.tokens, " ")
 	return p
 }
 
-// String returns the template string.
+// String returns the template, e.g. "connection refused from <IP>".
 func (p *Pattern) String() string { return p.str }
 
-// Hash returns a stable hash for deduplication.
+// Params returns the values the template placeholders stand for, in order.
+func (p *Pattern) Params() []string { return p.params }
+
+// Hash returns a stable hash for deduplication, computed with scheme HashVersion.
 func (p *Pattern) Hash() string { return p.hash }
 
 // WeakEqual returns true if this pattern is effectively the same as other (allow one token diff).
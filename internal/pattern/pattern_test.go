@@ -10,9 +10,9 @@ func TestNew(t *testing.T) {
 		line   string
 		expect string
 	}{
-		{"ERROR connection refused from 192.168.1.1", "ERROR connection refused from"},
-		{"WARN timeout after 5000 ms", "WARN timeout after ms"},
-		{"user login id=12345", "user login"},
+		{"ERROR connection refused from 192.168.1.1", "ERROR connection refused from <IP>"},
+		{"WARN timeout after 5000 ms", "WARN timeout after <NUM> ms"},
+		{"user login id=12345", "user login id=<NUM>"},
 	}
 	for _, tt := range tests {
 		p := New(tt.line)
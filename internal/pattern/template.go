@@ -0,0 +1,217 @@
+package pattern
+
+import (
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Template placeholders.
+const (
+	Num      = "<NUM>"
+	IP       = "<IP>"
+	UUID     = "<UUID>"
+	Wildcard = "<*>" // any other variable: hex ids, quoted or bracketed text, tokens with digits
+)
+
+var number = regexp.MustCompile(`^[-+]?[0-9]+([.,][0-9]+)?$`)
+
+var maskPlaceholder = regexp.MustCompile(`^<[A-Z0-9_]+>$`)
+
+// param is a placeholder value and the position of its placeholder in the line.
+type param struct {
+	at  int
+	val string
+}
+
+// template splits line into template tokens, with variable tokens replaced by placeholders,
+// and returns the replaced values in order. masked lists the placeholders already put into line by a
+// Masker; those are kept as they are.
+func template(line string, masked []span) ([]string, []string) {
+	params := make([]param, 0, len(masked))
+	for _, s := range masked {
+		params = append(params, param{at: s.start, val: s.val})
+	}
+	fields := splitFields(line)
+	toks := make([]string, len(fields))
+	for i, f := range fields {
+		toks[i] = templateToken(line[f[0]:f[1]], f[0], &params)
+	}
+	if len(params) == 0 {
+		return toks, nil
+	}
+	sort.SliceStable(params, func(i, j int) bool { return params[i].at < params[j].at })
+	vals := make([]string, len(params))
+	for i, p := range params {
+		vals[i] = p.val
+	}
+	return toks, vals
+}
+
+// templateToken renders field f, which starts at position at. Trailing punctuation is kept,
+// and in key=value fields only the value is considered.
+func templateToken(f string, at int, params *[]param) string {
+	core := strings.TrimRight(f, ".,;:=")
+	suffix := f[len(core):]
+	if core == "" || maskPlaceholder.MatchString(core) {
+		return f
+	}
+	if ph, val := placeholder(core); ph != "" {
+		*params = append(*params, param{at: at, val: val})
+		return ph + suffix
+	}
+	if k, v, ok := strings.Cut(core, "="); ok && k != "" && v != "" && !strings.ContainsAny(k, `"'[({`) {
+		return k + "=" + templateToken(v, at+len(k)+1, params) + suffix
+	}
+	if strings.ContainsAny(core, "0123456789") && !strings.Contains(core, "<") {
+		*params = append(*params, param{at: at, val: core})
+		return Wildcard + suffix
+	}
+	return f
+}
+
+// placeholder classifies a whole token and returns its placeholder and parameter value, or
+// "" when the token is not a variable on its own.
+func placeholder(s string) (ph, val string) {
+	switch {
+	case isIP(s):
+		return IP, s
+	case uuid.MatchString(s):
+		return UUID, s
+	case number.MatchString(s):
+		return Num, s
+	case hex.MatchString(s) && (len(s) >= 8 || strings.ContainsAny(s, "0123456789")):
+		return Wildcard, s
+	}
+	if inner, ok := enclosed(s); ok && !maskPlaceholder.MatchString(inner) {
+		return Wildcard, inner
+	}
+	return "", ""
+}
+
+// isIP reports whether s is an IPv4 or IPv6 address, optionally with a port.
+func isIP(s string) bool {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return strings.ContainsAny(s, ".:") && net.ParseIP(s) != nil
+}
+
+// enclosed returns the text inside s if all of s is one quoted or bracketed region.
+func enclosed(s string) (string, bool) {
+	if len(s) < 2 {
+		return "", false
+	}
+	open, end := s[0], s[len(s)-1]
+	switch {
+	case (open == '"' || open == '\'') && end == open,
+		open == '[' && end == ']', open == '(' && end == ')', open == '{' && end == '}':
+		// The opener at 0 must close exactly at the last byte, not earlier as in "(a)(b)".
+		if _, u := scanFields(s, nil); u == nil {
+			if _, u := scanFields(s[:len(s)-1], nil); len(u) > 0 && u[0] == 0 {
+				return s[1 : len(s)-1], true
+			}
+		}
+	}
+	return "", false
+}
+
+// splitFields splits s on whitespace, keeping quoted and bracketed regions together, and
+// returns the [start, end) of each field. Quotes only open a region at the start of a field
+// or after '=', so apostrophes in words do not. An opener that is never closed is treated
+// as a plain character. Each pass marks every opener left open as plain; when that does not
+// settle the line within maxPasses, quotes and brackets do not group at all, so the time
+// stays linear in the length of the line.
+func splitFields(s string) [][2]int {
+	const maxPasses = 4
+	var literal map[int]bool
+	for range maxPasses {
+		fields, unclosed := scanFields(s, literal)
+		if len(unclosed) == 0 {
+			return fields
+		}
+		if literal == nil {
+			literal = make(map[int]bool)
+		}
+		for _, i := range unclosed {
+			literal[i] = true
+		}
+	}
+	var fields [][2]int
+	start := -1
+	for i := 0; i <= len(s); i++ {
+		if i == len(s) || isSpace(s[i]) {
+			if start >= 0 {
+				fields = append(fields, [2]int{start, i})
+				start = -1
+			}
+		} else if start < 0 {
+			start = i
+		}
+	}
+	return fields
+}
+
+// scanFields does one pass of splitFields. It returns the indexes of the openers that were
+// still open at the end of s, outermost first, or nil.
+func scanFields(s string, literal map[int]bool) ([][2]int, []int) {
+	var fields [][2]int
+	var quote byte
+	var stack []int // indexes of open brackets
+	quoteStart, fieldStart := -1, -1
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote == 0 && len(stack) == 0 && isSpace(c) {
+			if fieldStart >= 0 {
+				fields = append(fields, [2]int{fieldStart, i})
+				fieldStart = -1
+			}
+			continue
+		}
+		if fieldStart < 0 {
+			fieldStart = i
+		}
+		if literal[i] {
+			continue
+		}
+		switch {
+		case quote != 0:
+			if c == quote && s[i-1] != '\\' {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == fieldStart || s[i-1] == '=' {
+				quote, quoteStart = c, i
+			}
+		case c == '[' || c == '(' || c == '{':
+			stack = append(stack, i)
+		case len(stack) > 0 && c == closer(s[stack[len(stack)-1]]):
+			stack = stack[:len(stack)-1]
+		}
+	}
+	if quote != 0 {
+		stack = append(stack, quoteStart)
+	}
+	if len(stack) > 0 {
+		return nil, stack
+	}
+	if fieldStart >= 0 {
+		fields = append(fields, [2]int{fieldStart, len(s)})
+	}
+	return fields, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func closer(open byte) byte {
+	switch open {
+	case '[':
+		return ']'
+	case '(':
+		return ')'
+	}
+	return '}'
+}
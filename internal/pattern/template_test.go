@@ -0,0 +1,59 @@
+package pattern
+
+import (
+	"crypto/md5"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew_Template(t *testing.T) {
+	tests := []struct {
+		line   string
+		tmpl   string
+		params []string
+	}{
+		{"ERROR connection refused to 10.0.0.1:5432", "ERROR connection refused to <IP>", []string{"10.0.0.1:5432"}},
+		{"dial fe80::1 failed, retry 3 of 5.", "dial <IP> failed, retry <NUM> of <NUM>.", []string{"fe80::1", "3", "5"}},
+		{"req a1b2c3d4-e5f6-7890-abcd-ef1234567890 done", "req <UUID> done", []string{"a1b2c3d4-e5f6-7890-abcd-ef1234567890"}},
+		{"tx deadbeef committed by worker12", "tx <*> committed by <*>", []string{"deadbeef", "worker12"}},
+		{`user="jane doe" status=403 path=/api`, "user=<*> status=<NUM> path=/api", []string{"jane doe", "403"}},
+		{"failed [db primary] (timeout) {\"a\": 1}", "failed <*> <*> <*>", []string{"db primary", "timeout", `"a": 1`}},
+		// Apostrophes and unclosed brackets are plain characters.
+		{"can't open (config", "can't open (config", nil},
+		{"cache face added", "cache face added", nil},
+	}
+	for _, tt := range tests {
+		p := New(tt.line)
+		if p.String() != tt.tmpl || !reflect.DeepEqual(p.Params(), tt.params) {
+			t.Errorf("New(%q) => %q %q, want %q %q", tt.line, p.String(), p.Params(), tt.tmpl, tt.params)
+		}
+	}
+}
+
+// The hash covers only the constant words, so rendering templates did not change it.
+func TestNew_HashIgnoresTemplate(t *testing.T) {
+	p := New("ERROR connection refused from 192.168.1.1 id=12345")
+	if want := fmt.Sprintf("%x", md5.Sum([]byte("ERROR connection refused from"))); p.Hash() != want {
+		t.Errorf("Hash = %s, want %s", p.Hash(), want)
+	}
+}
+
+// Unclosed openers are found in one pass, so a long line of them does not take quadratic time.
+func TestNew_UnclosedBrackets(t *testing.T) {
+	line := "ERROR " + strings.Repeat("( ", 16000) + "done (ok)"
+	start := time.Now()
+	p := New(line)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("New took %v on a %d byte line", d, len(line))
+	}
+	if want := "ERROR " + strings.Repeat("( ", 16000) + "done <*>"; p.String() != want {
+		t.Errorf("template = %.40q..., want the brackets kept as words", p.String())
+	}
+	// A quote left open inside a bracket and brackets left open around it still settle.
+	if p := New(`x [a=" ( b [c] {d`); p.String() != `x [a=" ( b <*> {d` {
+		t.Errorf("template = %q", p.String())
+	}
+}
@@ -26,6 +26,11 @@ type PatternEnt struct {
 // Save writes a snapshot to path (JSON). Creates parent dirs.
 func Save(path string, patterns []PatternEnt) error {
 	s := Snapshot{Timestamp: time.Now(), Patterns: patterns}
+	return s.Write(path)
+}
+
+// Write writes s to path (JSON) as is, keeping its timestamp. Creates parent dirs.
+func (s *Snapshot) Write(path string) error {
 	data, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
 		return err
@@ -51,3 +56,30 @@ func Load(path string) (*Snapshot, error) {
 	}
 	return &s, nil
 }
+
+// Rehash replaces the hash of every pattern with rehash(pattern), combining patterns that end
+// up with the same level and hash, and reports whether anything changed.
+func (s *Snapshot) Rehash(rehash func(PatternEnt) string) bool {
+	type key struct {
+		level types.Level
+		hash  string
+	}
+	changed := false
+	idx := make(map[key]int, len(s.Patterns))
+	out := s.Patterns[:0]
+	for _, p := range s.Patterns {
+		if h := rehash(p); h != p.Hash {
+			p.Hash = h
+			changed = true
+		}
+		k := key{p.Level, p.Hash}
+		if i, ok := idx[k]; ok {
+			out[i].Count += p.Count
+			continue
+		}
+		idx[k] = len(out)
+		out = append(out, p)
+	}
+	s.Patterns = out
+	return changed
+}
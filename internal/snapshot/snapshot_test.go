@@ -66,4 +66,29 @@ func TestSaveCreatesDir(t *testing.T) {
 	}
 }
 
-var _ = time.Time{} // use time in tests if needed
+func TestRehash(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	s := &Snapshot{Timestamp: ts, Patterns: []PatternEnt{
+		{Level: types.LevelError, Hash: "a", Count: 1},
+		{Level: types.LevelError, Hash: "b", Count: 2},
+		{Level: types.LevelWarn, Hash: "a", Count: 5},
+	}}
+	rename := map[string]string{"a": "ab", "b": "ab"}
+	if !s.Rehash(func(p PatternEnt) string { return rename[p.Hash] }) {
+		t.Fatal("Rehash reported no change")
+	}
+	if len(s.Patterns) != 2 || s.Patterns[0].Hash != "ab" || s.Patterns[0].Count != 3 || s.Patterns[1].Level != types.LevelWarn {
+		t.Errorf("Patterns = %+v", s.Patterns)
+	}
+	if s.Rehash(func(p PatternEnt) string { return p.Hash }) {
+		t.Error("identity Rehash reported a change")
+	}
+	path := filepath.Join(t.TempDir(), "snap.json")
+	if err := s.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil || !loaded.Timestamp.Equal(ts) {
+		t.Errorf("Write kept timestamp %v, %v", loaded, err)
+	}
+}
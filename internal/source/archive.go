@@ -0,0 +1,269 @@
+package source
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+	"github.com/ailert/ailert/internal/types"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// tarMagicOffset is where the "ustar" magic sits in a tar header block.
+const tarMagicOffset = 257
+
+// isArchive reports whether a file is compressed (gzip or zstd magic) or a tar archive
+// (ustar magic or a .tar/.tgz/.tzst name) rather than a plain log file.
+func isArchive(name string, head []byte) bool {
+	return compression(head) != "" || isTarHead(head) || isTarName(name)
+}
+
+func compression(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(head, zstdMagic):
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+func isTarHead(head []byte) bool {
+	return len(head) >= tarMagicOffset+5 && string(head[tarMagicOffset:tarMagicOffset+5]) == "ustar"
+}
+
+func isTarName(name string) bool {
+	name = strings.ToLower(filepath.Base(name))
+	for _, ext := range []string{".gz", ".zst"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tzst")
+}
+
+// decompress wraps r in a gzip or zstd reader when its first bytes say so.
+func decompress(r *bufio.Reader) (*bufio.Reader, func(), error) {
+	head, _ := r.Peek(len(zstdMagic))
+	switch compression(head) {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zr), func() { zr.Close() }, nil
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zr), zr.Close, nil
+	default:
+		return r, func() {}, nil
+	}
+}
+
+// readArchive reads a compressed file or tar archive once, decompressing as it goes. Tar
+// members are read one by one (compressed members too) and their records get a "member"
+// label with the name inside the archive. Such files are not appended to, so the checkpoint
+// covers the whole file: it is set when the last record is processed and a file that was
+// read completely is skipped on restart. With Tail the read waits for the file to be
+// removed so a glob or directory source does not read it again; such a source first checks
+// that the archive reads to the end and returns errIncomplete if not.
+func (f *FileSource) readArchive(ctx context.Context, path string, multi bool, file *os.File, head []byte, parse lineParser, recCh chan<- types.Record) error {
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	done := checkpoint.Checkpoint{Offset: fi.Size(), Inode: checkpoint.Inode(fi), Fingerprint: checkpoint.Fingerprint(head)}
+	if f.Checkpoints != nil && !f.Rescan {
+		if cp, ok := f.Checkpoints.Get(f.ID(), path); ok && cp == done {
+			return f.waitRemoved(ctx, path, multi)
+		}
+	}
+	if f.Tail && multi {
+		// logrotate may still be compressing the file: read it through before emitting
+		// anything, so a half-written archive is retried whole rather than read twice.
+		if err := checkArchive(path, file); err != nil {
+			return fmt.Errorf("%s: %w (%v)", path, errIncomplete, err)
+		}
+		if fi, err := file.Stat(); err != nil || fi.Size() != done.Offset {
+			return fmt.Errorf("%s: %w (still growing)", path, errIncomplete)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	// Hold one record back so the last one can carry the checkpoint.
+	var held *types.Record
+	send := func(rec types.Record) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case recCh <- rec:
+			return true
+		}
+	}
+	emit := func(rec types.Record, _ checkpoint.Checkpoint) bool {
+		if held != nil && !send(*held) {
+			return false
+		}
+		held = &rec
+		return true
+	}
+	r, closeR, err := decompress(bufio.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	defer closeR()
+	tarHead, _ := r.Peek(tarMagicOffset + 5)
+	if isTarHead(tarHead) || isTarName(path) {
+		err = f.readTar(path, r, parse, emit)
+	} else {
+		err = f.readStream(path, nil, r, parse, emit)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if held != nil {
+		if f.Checkpoints != nil {
+			held.Ack = func() { f.Checkpoints.Set(f.ID(), path, done) }
+		}
+		if !send(*held) {
+			return nil
+		}
+	}
+	return f.waitRemoved(ctx, path, multi)
+}
+
+// errIncomplete marks an archive that could not be read to the end, such as one that is still
+// being written. A following glob or directory source retries it on its next discovery.
+var errIncomplete = errors.New("incomplete archive")
+
+// checkArchive decompresses all of r, tar members included, and returns the first error.
+func checkArchive(path string, r io.Reader) error {
+	dr, closeR, err := decompress(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	defer closeR()
+	tarHead, _ := dr.Peek(tarMagicOffset + 5)
+	if !isTarHead(tarHead) && !isTarName(path) {
+		_, err := io.Copy(io.Discard, dr)
+		return err
+	}
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		mr, closeM, err := decompress(bufio.NewReader(tr))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, mr)
+		closeM()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readTar reads every regular file in a tar stream.
+func (f *FileSource) readTar(path string, r io.Reader, parse lineParser, emit func(types.Record, checkpoint.Checkpoint) bool) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		mr, closeM, err := decompress(bufio.NewReader(tr))
+		if err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+		err = f.readStream(path, map[string]string{"member": hdr.Name}, mr, parse, emit)
+		closeM()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readStream runs every line of r through a fresh line pipeline.
+func (f *FileSource) readStream(path string, labels map[string]string, r *bufio.Reader, parse lineParser, emit func(types.Record, checkpoint.Checkpoint) bool) error {
+	out, err := f.newLineOut(path, labels, parse, emit)
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" && !out.line(line, checkpoint.Checkpoint{}) {
+			return errStopped
+		}
+		if err == io.EOF {
+			if !out.flush() {
+				return errStopped
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// errStopped ends a read early because emit returned false (ctx is done).
+var errStopped = errors.New("stopped")
+
+// waitRemoved returns once ctx is done or, for a tailing glob or directory source, path is
+// gone. Without Tail it returns at once.
+func (f *FileSource) waitRemoved(ctx context.Context, path string, multi bool) error {
+	if !f.Tail {
+		return nil
+	}
+	poll := f.Poll
+	if poll <= 0 {
+		poll = DefaultTailPoll
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(poll):
+		}
+		if _, err := os.Stat(path); multi && os.IsNotExist(err) {
+			return nil
+		}
+	}
+}
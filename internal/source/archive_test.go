@@ -0,0 +1,163 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(data))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write([]byte(data))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarBytes(t *testing.T, members map[string][]byte, order ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "logs/", Typeflag: tar.TypeDir, Mode: 0o755})
+	for _, name := range order {
+		data := members[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileSource_Compressed(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"app.log.1.gz":  gzipBytes(t, "ERROR disk full\nINFO retry ok"),
+		"app.log.2.zst": zstdBytes(t, "ERROR disk full\nINFO retry ok\n"),
+		"rotated":       gzipBytes(t, "ERROR disk full\nINFO retry ok\n"), // detected by magic bytes
+	} {
+		path := writeFile(t, dir, name, data)
+		recs := readAll(t, &FileSource{Path: path})
+		if len(recs) != 2 || recs[0].Message != "ERROR disk full" || recs[1].Message != "INFO retry ok" {
+			t.Fatalf("%s: %+v", name, recs)
+		}
+		if recs[0].Labels["file"] != path {
+			t.Errorf("%s: labels = %v", name, recs[0].Labels)
+		}
+	}
+}
+
+func TestFileSource_Tar(t *testing.T) {
+	dir := t.TempDir()
+	members := map[string][]byte{
+		"logs/api.log":    []byte("ERROR api down\n"),
+		"logs/db.log.gz":  gzipBytes(t, "WARN slow query\n"),
+		"logs/worker.log": []byte("Exception in thread main\n\tat Job.run(Job.java:3)\n"),
+	}
+	order := []string{"logs/api.log", "logs/db.log.gz", "logs/worker.log"}
+	for name, data := range map[string][]byte{
+		"bundle.tar":    tarBytes(t, members, order...),
+		"bundle.tar.gz": gzipBytes(t, string(tarBytes(t, members, order...))),
+	} {
+		path := writeFile(t, dir, name, data)
+		recs := readAll(t, &FileSource{Path: path, Multiline: &MultilineRule{}})
+		if len(recs) != 3 {
+			t.Fatalf("%s: got %d records: %+v", name, len(recs), recs)
+		}
+		for i, want := range []struct{ member, msg string }{
+			{"logs/api.log", "ERROR api down"},
+			{"logs/db.log.gz", "WARN slow query"},
+			{"logs/worker.log", "Exception in thread main\n\tat Job.run(Job.java:3)"},
+		} {
+			if recs[i].Labels["member"] != want.member || recs[i].Message != want.msg || recs[i].Labels["file"] != path {
+				t.Errorf("%s: record %d = %+v", name, i, recs[i])
+			}
+		}
+	}
+}
+
+func TestFileSource_ArchiveCheckpoint(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "old.log.gz", gzipBytes(t, "ERROR one\nERROR two\n"))
+	cps := checkpoint.New("")
+	if recs := readAll(t, &FileSource{Path: path, Checkpoints: cps}); len(recs) != 2 {
+		t.Fatalf("first read: %+v", recs)
+	}
+	if recs := readAll(t, &FileSource{Path: path, Checkpoints: cps}); len(recs) != 0 {
+		t.Errorf("read again after checkpoint: %+v", recs)
+	}
+	if recs := readAll(t, &FileSource{Path: path, Checkpoints: cps, Rescan: true}); len(recs) != 2 {
+		t.Errorf("rescan: %+v", recs)
+	}
+}
+
+// A .gz that logrotate is still writing is retried on the next discovery instead of stopping
+// the source, and read once complete.
+func TestFileSource_DirectoryHalfWrittenGzip(t *testing.T) {
+	dir := t.TempDir()
+	full := gzipBytes(t, "ERROR one\nERROR two\n")
+	path := writeFile(t, dir, "app.log.1.gz", full[:len(full)/2])
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{Path: dir, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond, Discover: 20 * time.Millisecond}
+	recCh, errCh := src.Stream(ctx)
+	time.Sleep(100 * time.Millisecond) // a few discovery rounds on the truncated file
+	select {
+	case err := <-errCh:
+		t.Fatalf("source stopped on a half-written archive: %v", err)
+	case rec := <-recCh:
+		t.Fatalf("record from a half-written archive: %+v", rec)
+	default:
+	}
+	if err := os.WriteFile(path, full, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, recCh, 2, 2*time.Second); got[0] != "ERROR one" || got[1] != "ERROR two" {
+		t.Errorf("got %q", got)
+	}
+	select {
+	case rec := <-recCh:
+		t.Errorf("archive read twice: %+v", rec)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	for range recCh {
+	}
+}
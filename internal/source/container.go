@@ -0,0 +1,192 @@
+package source
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+)
+
+// maxContainerLine bounds a reassembled container log line; longer ones are emitted in pieces.
+const maxContainerLine = 1 << 20
+
+// Container log formats for FileSource.Container.
+const (
+	ContainerCRI    = "cri"    // <RFC3339Nano ts> <stream> <P|F> <content>
+	ContainerDocker = "docker" // {"log":"...\n","stream":"stdout","time":"..."}
+	ContainerAuto   = "auto"   // per line: docker when it starts with '{', else CRI
+)
+
+// Kubernetes log file names: /var/log/containers/<pod>_<namespace>_<container>-<id>.log
+// and /var/log/pods/<namespace>_<pod>_<uid>/<container>/<restart>.log.
+var (
+	containersFileName = regexp.MustCompile(`^([^_]+)_([^_]+)_(.+)-[0-9a-f]{64}\.log$`)
+	podsFilePath       = regexp.MustCompile(`(?:^|/)([^/_]+)_([^/_]+)_[0-9a-fA-F-]+/([^/]+)/[^/]+\.log$`)
+)
+
+// containerLabels derives namespace, pod and container labels from a Kubernetes log path.
+func containerLabels(path string) map[string]string {
+	if m := containersFileName.FindStringSubmatch(filepath.Base(path)); m != nil {
+		return map[string]string{"pod": m[1], "namespace": m[2], "container": m[3]}
+	}
+	if m := podsFilePath.FindStringSubmatch(filepath.ToSlash(path)); m != nil {
+		return map[string]string{"namespace": m[1], "pod": m[2], "container": m[3]}
+	}
+	return nil
+}
+
+// containerMeta is what the runtime recorded about one log line.
+type containerMeta struct {
+	ts     time.Time
+	stream string
+}
+
+// containerDecoder unwraps container runtime log lines (CRI or docker json-file) into the
+// application's own lines and reassembles lines the runtime split into partial entries.
+// The metadata of each line handed on is queued until the record built from it takes it.
+// Lines are handed on with a checkpoint no later than the start of any partial line still
+// pending, so a restart does not skip the beginning of another stream's unfinished line
+// (complete lines written after that start are read again instead).
+type containerDecoder struct {
+	format  string
+	pending map[string]*containerPartial // per stream: stdout and stderr partials interleave
+	queue   []containerMeta
+	cp      checkpoint.Checkpoint // position after the last raw line
+}
+
+type containerPartial struct {
+	meta  containerMeta
+	start checkpoint.Checkpoint // position before its first raw line
+	text  strings.Builder
+}
+
+func newContainerDecoder(format string) (*containerDecoder, error) {
+	switch format {
+	case "":
+		return nil, nil
+	case ContainerCRI, ContainerDocker, ContainerAuto:
+		return &containerDecoder{format: format, pending: make(map[string]*containerPartial)}, nil
+	default:
+		return nil, fmt.Errorf("container: unknown format %q", format)
+	}
+}
+
+// line decodes one raw line and passes each complete application line to next.
+func (d *containerDecoder) line(raw string, cp checkpoint.Checkpoint, next func(string, checkpoint.Checkpoint) bool) bool {
+	raw = strings.TrimRight(raw, "\r\n")
+	start := d.cp
+	if start.Inode != cp.Inode || start.Offset > cp.Offset {
+		start = checkpoint.Checkpoint{Inode: cp.Inode} // another file: its beginning
+	}
+	d.cp = cp
+	meta, content, partial, ok := d.decode(raw)
+	if !ok {
+		d.queue = append(d.queue, containerMeta{})
+		return next(raw, d.safe(cp))
+	}
+	p := d.pending[meta.stream]
+	if p == nil && !partial {
+		d.queue = append(d.queue, meta)
+		return next(content, d.safe(cp))
+	}
+	if p == nil {
+		p = &containerPartial{meta: meta, start: start}
+		d.pending[meta.stream] = p
+	}
+	p.text.WriteString(content)
+	if partial && p.text.Len() < maxContainerLine {
+		return true
+	}
+	delete(d.pending, meta.stream)
+	d.queue = append(d.queue, p.meta)
+	return next(p.text.String(), d.safe(cp))
+}
+
+// safe returns cp, or the start of the oldest pending partial line if that is earlier.
+func (d *containerDecoder) safe(cp checkpoint.Checkpoint) checkpoint.Checkpoint {
+	for _, p := range d.pending {
+		if p.start.Offset < cp.Offset {
+			cp = p.start
+		}
+	}
+	return cp
+}
+
+// flush hands on partial lines left at the end of input or of a rotated file.
+func (d *containerDecoder) flush(next func(string, checkpoint.Checkpoint) bool) bool {
+	for stream, p := range d.pending {
+		delete(d.pending, stream)
+		d.queue = append(d.queue, p.meta)
+		if !next(p.text.String(), d.safe(d.cp)) {
+			return false
+		}
+	}
+	return true
+}
+
+// take returns the metadata of the first line of a record made of text (one or more lines
+// joined with "\n") and drops the metadata of all its lines.
+func (d *containerDecoder) take(text string) containerMeta {
+	n := strings.Count(text, "\n") + 1
+	if len(d.queue) == 0 {
+		return containerMeta{}
+	}
+	meta := d.queue[0]
+	d.queue = d.queue[min(n, len(d.queue)):]
+	return meta
+}
+
+func (d *containerDecoder) decode(raw string) (containerMeta, string, bool, bool) {
+	format := d.format
+	if format == ContainerAuto {
+		format = ContainerCRI
+		if strings.HasPrefix(raw, "{") {
+			format = ContainerDocker
+		}
+	}
+	if format == ContainerDocker {
+		return decodeDockerLine(raw)
+	}
+	return decodeCRILine(raw)
+}
+
+// decodeCRILine parses "<ts> <stream> <tag> <content>"; tag P marks a partial line.
+func decodeCRILine(raw string) (containerMeta, string, bool, bool) {
+	tsStr, rest, ok := strings.Cut(raw, " ")
+	if !ok {
+		return containerMeta{}, "", false, false
+	}
+	ts, err := time.Parse(time.RFC3339Nano, tsStr)
+	if err != nil {
+		return containerMeta{}, "", false, false
+	}
+	stream, rest, ok := strings.Cut(rest, " ")
+	if !ok {
+		return containerMeta{}, "", false, false
+	}
+	tag, content, _ := strings.Cut(rest, " ")
+	partial := strings.Split(tag, ":")[0] == "P"
+	return containerMeta{ts: ts, stream: stream}, content, partial, true
+}
+
+// decodeDockerLine parses a json-file entry; a log value without a trailing newline is partial.
+func decodeDockerLine(raw string) (containerMeta, string, bool, bool) {
+	var e struct {
+		Log    *string `json:"log"`
+		Stream string  `json:"stream"`
+		Time   string  `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(raw), &e); err != nil || e.Log == nil {
+		return containerMeta{}, "", false, false
+	}
+	meta := containerMeta{stream: e.Stream}
+	if ts, err := time.Parse(time.RFC3339Nano, e.Time); err == nil {
+		meta.ts = ts
+	}
+	content, complete := strings.CutSuffix(*e.Log, "\n")
+	return meta, strings.TrimSuffix(content, "\r"), !complete, true
+}
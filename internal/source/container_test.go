@@ -0,0 +1,177 @@
+package source
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+	"github.com/ailert/ailert/internal/types"
+)
+
+func decodeAll(t *testing.T, format string, lines []string) ([]string, []containerMeta) {
+	t.Helper()
+	dec, err := newContainerDecoder(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	var metas []containerMeta
+	o := &lineOut{dec: dec, record: func(s string, _ checkpoint.Checkpoint) bool {
+		metas = append(metas, dec.take(s))
+		out = append(out, s)
+		return true
+	}}
+	for _, l := range lines {
+		o.line(l+"\n", checkpoint.Checkpoint{})
+	}
+	o.flush()
+	return out, metas
+}
+
+func TestContainer_CRIPartials(t *testing.T) {
+	got, metas := decodeAll(t, ContainerCRI, []string{
+		"2024-05-01T10:00:00.000000001Z stdout P ERROR request ",
+		"2024-05-01T10:00:00.5Z stderr F warn from stderr",
+		"2024-05-01T10:00:01Z stdout P failed: ",
+		"2024-05-01T10:00:02Z stdout F timeout",
+		"2024-05-01T10:00:03Z stdout F INFO done",
+	})
+	want := []string{"warn from stderr", "ERROR request failed: timeout", "INFO done"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q", got)
+	}
+	if metas[0].stream != "stderr" || metas[1].stream != "stdout" {
+		t.Errorf("streams = %+v", metas)
+	}
+	if !metas[1].ts.Equal(time.Date(2024, 5, 1, 10, 0, 0, 1, time.UTC)) {
+		t.Errorf("reassembled line ts = %v, want the first partial's", metas[1].ts)
+	}
+}
+
+func TestContainer_DockerPartials(t *testing.T) {
+	got, metas := decodeAll(t, ContainerDocker, []string{
+		`{"log":"ERROR part one, ","stream":"stdout","time":"2024-05-01T10:00:00.123Z"}`,
+		`{"log":"part two\n","stream":"stdout","time":"2024-05-01T10:00:00.124Z"}`,
+		`{"log":"INFO ok\r\n","stream":"stderr","time":"2024-05-01T10:00:01Z"}`,
+		`{"log":"trailing","stream":"stdout","time":"2024-05-01T10:00:02Z"}`,
+	})
+	want := []string{"ERROR part one, part two", "INFO ok", "trailing"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got %q", got)
+	}
+	if metas[1].stream != "stderr" || !metas[0].ts.Equal(time.Date(2024, 5, 1, 10, 0, 0, 123e6, time.UTC)) {
+		t.Errorf("metas = %+v", metas)
+	}
+}
+
+func TestContainer_AutoAndUnknown(t *testing.T) {
+	got, metas := decodeAll(t, ContainerAuto, []string{
+		`{"log":"from docker\n","stream":"stdout","time":"2024-05-01T10:00:00Z"}`,
+		"2024-05-01T10:00:01Z stdout F from cri",
+		"not a runtime line",
+	})
+	if strings.Join(got, "|") != "from docker|from cri|not a runtime line" {
+		t.Fatalf("got %q", got)
+	}
+	if !metas[2].ts.IsZero() || metas[2].stream != "" {
+		t.Errorf("unwrapped line meta = %+v", metas[2])
+	}
+	if _, err := newContainerDecoder("podman"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestContainerLabels(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	for path, want := range map[string]map[string]string{
+		"/var/log/containers/api-7d9f_prod_server-" + id + ".log":                       {"pod": "api-7d9f", "namespace": "prod", "container": "server"},
+		"/var/log/pods/prod_api-7d9f_0b1c2d3e-aaaa-bbbb-cccc-1234567890ab/server/0.log": {"pod": "api-7d9f", "namespace": "prod", "container": "server"},
+		"/var/log/app.log": nil,
+	} {
+		got := containerLabels(path)
+		if len(got) != len(want) {
+			t.Errorf("%s: labels = %v", path, got)
+			continue
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: %s = %q, want %q", path, k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestFileSource_Container(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "containers")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "api-7d9f_prod_server-"+strings.Repeat("0", 64)+".log")
+	content := "2024-05-01T10:00:00Z stdout P {\"level\":\"error\",\n" +
+		"2024-05-01T10:00:00Z stdout F \"msg\":\"db down\"}\n" +
+		"2024-05-01T10:00:05Z stderr F plain line\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	src := &FileSource{Path: path, SourceID: "k8s", Container: ContainerCRI, Format: FormatMapping{Type: "json"}}
+	recs := readAll(t, src)
+	if len(recs) != 2 {
+		t.Fatalf("got %d records: %+v", len(recs), recs)
+	}
+	r := recs[0]
+	if r.Message != "db down" || r.Level != types.LevelError || r.Labels["stream"] != "stdout" || r.Labels["pod"] != "api-7d9f" || r.Labels["namespace"] != "prod" || r.Labels["container"] != "server" {
+		t.Errorf("record 0 = %+v", r)
+	}
+	if !r.Timestamp.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("record 0 timestamp = %v", r.Timestamp)
+	}
+	if recs[1].Message != "plain line" || recs[1].Labels["stream"] != "stderr" || !recs[1].Timestamp.Equal(time.Date(2024, 5, 1, 10, 0, 5, 0, time.UTC)) {
+		t.Errorf("record 1 = %+v", recs[1])
+	}
+}
+
+// A record of one stream does not move the checkpoint past the start of another stream's
+// partial line, so the partial is read whole after a restart (and the stderr line again).
+func TestFileSource_ContainerCheckpointKeepsPartial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("2024-05-01T10:00:00Z stdout F INFO start\n"+
+		"2024-05-01T10:00:01Z stdout P ERROR request \n"+
+		"2024-05-01T10:00:02Z stderr F warn from stderr\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cps := checkpoint.New("")
+	run := func(n int) []string {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		src := &FileSource{Path: path, SourceID: "k8s", Container: ContainerCRI, Tail: true, Poll: 10 * time.Millisecond, Checkpoints: cps}
+		recCh, _ := src.Stream(ctx)
+		var got []string
+		deadline := time.After(2 * time.Second)
+		for len(got) < n {
+			select {
+			case rec := <-recCh:
+				if rec.Ack != nil {
+					rec.Ack()
+				}
+				got = append(got, rec.Message)
+			case <-deadline:
+				t.Fatalf("timed out after %q", got)
+			}
+		}
+		cancel()
+		for range recCh {
+		}
+		return got
+	}
+	if got := run(2); got[0] != "INFO start" || got[1] != "warn from stderr" {
+		t.Fatalf("first run: %q", got)
+	}
+	appendLines(t, path, "2024-05-01T10:00:03Z stdout F failed")
+	if got := run(2); got[0] != "warn from stderr" || got[1] != "ERROR request failed" {
+		t.Errorf("after restart: %q", got)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ailert/ailert/internal/types"
@@ -13,12 +16,33 @@ import (
 // Columns must be: timestamp, level, message, labels (optional, JSON string), source_id (optional).
 const DefaultDuckDBQuery = `SELECT timestamp, level, message, COALESCE(labels, '{}') AS labels, COALESCE(source_id, '') AS source_id FROM records ORDER BY timestamp`
 
+// DefaultCursor is the cursor column of incremental query sources when none is named.
+const DefaultCursor = "timestamp"
+
+// Watermarks persists the position of incremental query sources: the largest cursor value
+// processed so far, per source ID, as text plus the SQL type it was read as.
+type Watermarks interface {
+	Watermark(sourceID string) (value, sqlType string, ok bool)
+	SetWatermark(sourceID, value, sqlType string)
+}
+
 // DuckDBSource reads records from a DuckDB database by running a query.
 // The query must return columns: timestamp (TIMESTAMP), level (VARCHAR), message (VARCHAR), labels (VARCHAR JSON, optional), source_id (VARCHAR, optional).
+//
+// In incremental mode the query is wrapped to return only rows whose Cursor column (a result
+// column; "timestamp" by default) is greater than the watermark, in cursor order. The
+// watermark advances as records are processed (Record.Ack) and is persisted in Watermarks,
+// so a restart resumes after the last processed row. Rows added later with a cursor value
+// equal to the watermark are not read; use a strictly increasing column (e.g. an id) when
+// that matters.
 type DuckDBSource struct {
-	DB      *sql.DB
-	Query   string
-	SourceID string
+	DB          *sql.DB
+	Query       string
+	SourceID    string
+	Incremental bool
+	Cursor      string        // incremental: cursor column; "" means DefaultCursor
+	Interval    time.Duration // incremental: poll for new rows this often; 0 runs once
+	Watermarks  Watermarks    // incremental: persists the watermark; nil keeps it in memory
 }
 
 // ID implements Source.
@@ -29,7 +53,8 @@ func (d *DuckDBSource) ID() string {
 	return "duckdb"
 }
 
-// Stream implements Source. Runs the query once and streams rows as Records.
+// Stream implements Source. Runs the query once and streams rows as Records; in
+// incremental mode with an Interval, runs it again for new rows until ctx is done.
 func (d *DuckDBSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
 	recCh := make(chan types.Record, 64)
 	errCh := make(chan error, 1)
@@ -40,46 +65,152 @@ func (d *DuckDBSource) Stream(ctx context.Context) (<-chan types.Record, <-chan
 		if query == "" {
 			query = DefaultDuckDBQuery
 		}
-		rows, err := d.DB.QueryContext(ctx, query)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		defer rows.Close()
-		for rows.Next() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-			var ts time.Time
-			var levelStr, message, labelsJSON, sourceID string
-			if err := rows.Scan(&ts, &levelStr, &message, &labelsJSON, &sourceID); err != nil {
-				errCh <- err
-				return
-			}
-			level := types.ParseLevel(levelStr)
-			labels := make(map[string]string)
-			if labelsJSON != "" && labelsJSON != "{}" {
-				_ = json.Unmarshal([]byte(labelsJSON), &labels)
-			}
-			if sourceID == "" {
-				sourceID = d.SourceID
-			}
-			if sourceID == "" {
-				sourceID = "duckdb"
-			}
-			recCh <- types.Record{
-				Timestamp: ts,
-				Level:    level,
-				Message:   message,
-				Labels:    labels,
-				SourceID:  sourceID,
+		var err error
+		if d.Incremental {
+			wm := &watermark{}
+			if d.Watermarks != nil {
+				wm.value, wm.sqlType, wm.ok = d.Watermarks.Watermark(d.ID())
 			}
+			err = poll(ctx, d.ID(), d.Interval, func(ctx context.Context) error {
+				return d.readSince(ctx, query, wm, recCh)
+			})
+		} else {
+			err = d.read(ctx, query, nil, "", nil, recCh)
 		}
-		if err := rows.Err(); err != nil {
+		if err != nil && ctx.Err() == nil {
 			errCh <- err
 		}
 	}()
 	return recCh, errCh
 }
+
+// watermark is the in-memory position of an incremental source: the cursor value of the
+// last row emitted.
+type watermark struct {
+	value   string
+	sqlType string
+	ok      bool
+}
+
+// sqlTypeName guards the type name spliced into the watermark CAST.
+var sqlTypeName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_ ]*(\(\d+(,\s*\d+)?\))?$`)
+
+// readSince runs the query for rows past the watermark.
+func (d *DuckDBSource) readSince(ctx context.Context, query string, wm *watermark, recCh chan<- types.Record) error {
+	cursor := d.Cursor
+	if cursor == "" {
+		cursor = DefaultCursor
+	}
+	col := `q."` + strings.ReplaceAll(cursor, `"`, `""`) + `"`
+	q := "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(query), ";") + ") AS q"
+	var args []any
+	if wm.ok {
+		if !sqlTypeName.MatchString(wm.sqlType) {
+			return fmt.Errorf("duckdb: bad watermark type %q", wm.sqlType)
+		}
+		q += " WHERE " + col + " > CAST(? AS " + wm.sqlType + ")"
+		args = append(args, wm.value)
+	}
+	q += " ORDER BY " + col
+	return d.read(ctx, q, args, cursor, wm, recCh)
+}
+
+// read runs q and emits one Record per row. The first five columns are, in order,
+// timestamp, level, message, labels and source_id (the last two may be missing). With a
+// cursor column, each record's Ack persists that row's cursor value as the watermark.
+func (d *DuckDBSource) read(ctx context.Context, q string, args []any, cursor string, wm *watermark, recCh chan<- types.Record) error {
+	rows, err := d.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	if len(colTypes) < 3 {
+		return fmt.Errorf("duckdb: query returns %d columns, want at least timestamp, level, message", len(colTypes))
+	}
+	cursorIdx := -1
+	for i, c := range colTypes {
+		if cursor != "" && c.Name() == cursor {
+			cursorIdx = i
+		}
+	}
+	if cursor != "" && cursorIdx < 0 {
+		return fmt.Errorf("duckdb: cursor column %q not in query result", cursor)
+	}
+	vals := make([]any, len(colTypes))
+	ptrs := make([]any, len(colTypes))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		ts, ok := vals[0].(time.Time)
+		if !ok {
+			return fmt.Errorf("duckdb: timestamp column is %T, want TIMESTAMP", vals[0])
+		}
+		rec := types.Record{
+			Timestamp: ts,
+			Level:     types.ParseLevel(sqlString(vals[1])),
+			Message:   sqlString(vals[2]),
+			Labels:    make(map[string]string),
+		}
+		if len(vals) > 3 {
+			if labelsJSON := sqlString(vals[3]); labelsJSON != "" && labelsJSON != "{}" {
+				_ = json.Unmarshal([]byte(labelsJSON), &rec.Labels)
+			}
+		}
+		if len(vals) > 4 {
+			rec.SourceID = sqlString(vals[4])
+		}
+		if rec.SourceID == "" {
+			rec.SourceID = d.ID()
+		}
+		if cursorIdx >= 0 && vals[cursorIdx] != nil {
+			sqlType := colTypes[cursorIdx].DatabaseTypeName()
+			value := watermarkText(vals[cursorIdx], sqlType)
+			*wm = watermark{value: value, sqlType: sqlType, ok: true}
+			if d.Watermarks != nil {
+				id := d.ID()
+				rec.Ack = func() { d.Watermarks.SetWatermark(id, value, sqlType) }
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case recCh <- rec:
+		}
+	}
+	return rows.Err()
+}
+
+// sqlString converts a scanned column value to a string ("" for NULL).
+func sqlString(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// watermarkText renders a cursor value as text that CAST(? AS sqlType) reads back.
+func watermarkText(v any, sqlType string) string {
+	t, ok := v.(time.Time)
+	if !ok {
+		return sqlString(v)
+	}
+	text := t.UTC().Format("2006-01-02 15:04:05.999999")
+	if strings.Contains(sqlType, "TZ") || strings.Contains(sqlType, "TIME ZONE") {
+		text += "+00"
+	}
+	return text
+}
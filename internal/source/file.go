@@ -3,19 +3,61 @@ package source
 import (
 	"bufio"
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/ailert/ailert/internal/checkpoint"
 	"github.com/ailert/ailert/internal/types"
 )
 
+const (
+	// DefaultTailPoll is how often a tailing FileSource checks for new data, rotation and truncation.
+	DefaultTailPoll = 250 * time.Millisecond
+	// DefaultDiscover is how often a tailing glob/directory FileSource looks for new files.
+	DefaultDiscover = 5 * time.Second
+)
+
 // FileSource tails a file (or reads it fully) and emits one Record per line.
-// No format mapping: each line is the message, level is detected from content.
+// Without a Format each line is the message and the level is detected from content;
+// with one, structured lines are decoded into timestamp, level, message and labels.
+//
+// With Tail set the source behaves like tail -F: after reaching the end it keeps polling for
+// appended lines until ctx is done. Logrotate-style rename/create is detected by comparing the
+// open file with the one currently at Path (the rest of the old file is drained first), and
+// copytruncate is detected when the file shrinks below the read offset.
+//
+// With Checkpoints set, reading resumes from the stored offset when the file at Path is still
+// the same one (same inode and leading-bytes fingerprint), and each record's Ack advances the
+// checkpoint. Rescan ignores stored checkpoints and reads from the start.
+//
+// Path may also be a glob (e.g. /var/log/app/*.log) or a directory; then every matching
+// regular file is read, and with Tail new files are discovered while running. Each Record
+// carries a "file" label with the path it was read from. A file that turns up under a new
+// matching name after a rename rotation (app.log to app.log.1) is recognized by inode and
+// fingerprint and read on from where its old name left off, not from the start.
+//
+// Container unwraps Kubernetes node logs written by the container runtime (CRI or docker
+// json-file): partial lines are reassembled, the runtime timestamp becomes Record.Timestamp,
+// the stream becomes a "stream" label, and namespace, pod and container labels are derived
+// from /var/log/containers or /var/log/pods file names. Format and Multiline then apply to
+// the application's own lines.
 type FileSource struct {
-	Path   string
-	SourceID string
-	Tail   bool // if true, follow file like tail -f; else read once
+	Path        string
+	SourceID    string
+	Tail        bool             // if true, follow file like tail -F; else read once
+	Poll        time.Duration    // tail poll interval; 0 means DefaultTailPoll
+	Discover    time.Duration    // glob/directory with Tail: rescan interval; 0 means DefaultDiscover
+	Checkpoints checkpoint.Store // optional; resume position across restarts
+	Rescan      bool             // if true, ignore stored checkpoints
+	Format      FormatMapping    // optional; decode structured lines (json, logfmt, regex)
+	Multiline   *MultilineRule   // optional; join continuation lines (stack traces) into one record
+	Container   string           // optional; "cri", "docker" or "auto": unwrap container runtime logs
 }
 
 // ID implements Source.
@@ -33,47 +75,572 @@ func (f *FileSource) Stream(ctx context.Context) (<-chan types.Record, <-chan er
 	go func() {
 		defer close(recCh)
 		defer close(errCh)
-		file, err := os.Open(f.Path)
+		parse, err := f.Format.parser()
 		if err != nil {
 			errCh <- err
 			return
 		}
-		defer file.Close()
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+		if _, err := f.Multiline.newJoiner(); err != nil {
+			errCh <- err
+			return
+		}
+		if _, err := newContainerDecoder(f.Container); err != nil {
+			errCh <- err
+			return
+		}
+		switch {
+		case !f.isMulti():
+			err = f.readFile(ctx, f.Path, false, nil, parse, recCh)
+		case f.Tail:
+			err = f.followMatches(ctx, parse, recCh)
+		default:
+			err = f.readMatches(ctx, parse, recCh)
+		}
+		if err != nil {
+			errCh <- err
+		}
+	}()
+	return recCh, errCh
+}
+
+// isMulti reports whether Path names a set of files (a glob or a directory) rather than one file.
+func (f *FileSource) isMulti() bool {
+	if strings.ContainsAny(f.Path, "*?[") {
+		return true
+	}
+	fi, err := os.Stat(f.Path)
+	return err == nil && fi.IsDir()
+}
+
+// match returns the regular files currently matched by Path (a directory matches its direct children).
+func (f *FileSource) match() ([]string, error) {
+	glob := f.Path
+	if fi, err := os.Stat(f.Path); err == nil && fi.IsDir() {
+		glob = filepath.Join(f.Path, "*")
+	}
+	paths, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	out := paths[:0]
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// readMatches reads every matched file once, in name order.
+func (f *FileSource) readMatches(ctx context.Context, parse lineParser, recCh chan<- types.Record) error {
+	paths, err := f.match()
+	if err != nil {
+		return err
+	}
+	h := f.newHandoff()
+	for _, p := range paths {
+		if err := f.readFile(ctx, p, true, h, parse, recCh); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// followMatches tails every matched file concurrently and re-evaluates Path every Discover
+// interval so files created while running are picked up. A follower ends when its file is
+// removed; it is started again if the path reappears. A new path whose file another follower
+// still has open under its old name waits for that follower to notice the rotation. An
+// archive that does not read to the end yet (logrotate still compressing it) is logged and
+// tried again on the next round.
+func (f *FileSource) followMatches(ctx context.Context, parse lineParser, recCh chan<- types.Record) error {
+	discover := f.Discover
+	if discover <= 0 {
+		discover = DefaultDiscover
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		active = make(map[string]bool)
+		fatal  = make(chan error, 1)
+		h      = f.newHandoff()
+	)
+	defer wg.Wait()
+	for {
+		paths, err := f.match()
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			mu.Lock()
+			if active[p] {
+				mu.Unlock()
+				continue
 			}
-			line := strings.TrimSpace(scanner.Text())
-			if line == "" {
+			if fi, err := os.Stat(p); err == nil && h.isOpen(checkpoint.Inode(fi)) {
+				mu.Unlock()
 				continue
 			}
-			recCh <- types.Record{
-				Timestamp: time.Now(),
-				Level:     types.LevelUnknown,
-				Message:   line,
-				SourceID:  f.ID(),
+			active[p] = true
+			mu.Unlock()
+			wg.Add(1)
+			go func(path string) {
+				defer wg.Done()
+				err := f.readFile(ctx, path, true, h, parse, recCh)
+				mu.Lock()
+				delete(active, path)
+				mu.Unlock()
+				if errors.Is(err, errIncomplete) {
+					fmt.Fprintf(os.Stderr, "source %s: %v; retrying\n", f.ID(), err)
+					return
+				}
+				if err != nil && !os.IsNotExist(err) {
+					select {
+					case fatal <- err:
+					default:
+					}
+				}
+			}(p)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-fatal:
+			return err
+		case <-time.After(discover):
+		}
+	}
+}
+
+// handoff tracks the files the followers of a tailing glob/directory source have open, and
+// where reading stopped in files that were renamed away (and, at start, the stored
+// checkpoints), so a file found again under another name is not read twice. Methods are
+// no-ops on a nil handoff.
+type handoff struct {
+	mu   sync.Mutex
+	open map[uint64]bool // inodes of the files being followed
+	left []checkpoint.Checkpoint
+}
+
+// newHandoff returns a handoff that knows the stored checkpoints of the source, so a file
+// renamed while ailert was not running is recognized too.
+func (f *FileSource) newHandoff() *handoff {
+	h := &handoff{open: make(map[uint64]bool)}
+	if f.Checkpoints != nil && !f.Rescan {
+		for _, cp := range f.Checkpoints.List(f.ID()) {
+			h.leave(cp)
+		}
+	}
+	return h
+}
+
+// maxHandoffs bounds handoff.left; files rotated to names outside Path are never taken.
+const maxHandoffs = 1024
+
+// isOpen reports whether a follower has the file with inode open.
+func (h *handoff) isOpen(inode uint64) bool {
+	if h == nil || inode == 0 {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.open[inode]
+}
+
+func (h *handoff) opened(inode uint64) {
+	if h == nil || inode == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.open[inode] = true
+}
+
+func (h *handoff) closed(inode uint64) {
+	if h == nil || inode == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.open, inode)
+}
+
+// leave records the read position of a file that may turn up under another name.
+func (h *handoff) leave(cp checkpoint.Checkpoint) {
+	if h == nil || cp.Offset <= 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.left) >= maxHandoffs {
+		h.left = h.left[1:]
+	}
+	h.left = append(h.left, cp)
+}
+
+// take removes and returns the furthest read position left for the file open in t.
+func (h *handoff) take(t *tailer) (checkpoint.Checkpoint, bool, error) {
+	if h == nil {
+		return checkpoint.Checkpoint{}, false, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	best := -1
+	for i, cp := range h.left {
+		if best >= 0 && cp.Offset <= h.left[best].Offset {
+			continue
+		}
+		ok, err := t.matches(cp)
+		if err != nil {
+			return checkpoint.Checkpoint{}, false, err
+		}
+		if ok {
+			best = i
+		}
+	}
+	if best < 0 {
+		return checkpoint.Checkpoint{}, false, nil
+	}
+	cp := h.left[best]
+	h.left = append(h.left[:best], h.left[best+1:]...)
+	return cp, true, nil
+}
+
+// readFile reads (and with Tail, follows) one file, labelling each record with its path.
+// In multi mode the read ends once the file has been removed and fully drained. Compressed
+// files and tar archives are handed to readArchive. h, when set, is shared by the followers
+// of a glob/directory source.
+func (f *FileSource) readFile(ctx context.Context, path string, multi bool, h *handoff, parse lineParser, recCh chan<- types.Record) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, checkpoint.FingerprintSize)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		file.Close()
+		return err
+	}
+	if head = head[:n]; isArchive(path, head) {
+		defer file.Close()
+		return f.readArchive(ctx, path, multi, file, head, parse, recCh)
+	}
+	t := &tailer{r: bufio.NewReader(file)}
+	if err := t.open(file); err != nil {
+		file.Close()
+		return err
+	}
+	defer func() { t.file.Close() }()
+	h.opened(t.inode)
+	defer func() { h.closed(t.inode) }()
+	if err := f.resume(t, path, h); err != nil {
+		return err
+	}
+	out, err := f.newLineOut(path, nil, parse, func(rec types.Record, cp checkpoint.Checkpoint) bool {
+		if f.Checkpoints != nil {
+			rec.Ack = func() { f.Checkpoints.Set(f.ID(), path, cp) }
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case recCh <- rec:
+			return true
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if out.dec != nil {
+		out.dec.cp = t.checkpoint() // where the first line read starts
+	}
+	return f.follow(ctx, path, multi, h, t, out)
+}
+
+// newLineOut builds the line pipeline of one file (or archive member): container log decoding,
+// multiline joining, then a Record per line with the file label, the given labels and Format
+// applied, handed to emit with the checkpoint after its last line.
+func (f *FileSource) newLineOut(path string, labels map[string]string, parse lineParser, emit func(types.Record, checkpoint.Checkpoint) bool) (*lineOut, error) {
+	j, err := f.Multiline.newJoiner()
+	if err != nil {
+		return nil, err
+	}
+	dec, err := newContainerDecoder(f.Container)
+	if err != nil {
+		return nil, err
+	}
+	var podLabels map[string]string
+	if dec != nil {
+		podLabels = containerLabels(path)
+	}
+	record := func(line string, cp checkpoint.Checkpoint) bool {
+		var meta containerMeta
+		if dec != nil {
+			meta = dec.take(line)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return true
+		}
+		rec := types.Record{
+			Timestamp: time.Now(),
+			Level:     types.LevelUnknown,
+			Message:   line,
+			Labels:    map[string]string{"file": path},
+			SourceID:  f.ID(),
+		}
+		if !meta.ts.IsZero() {
+			rec.Timestamp = meta.ts
+		}
+		setLabel(&rec, "stream", meta.stream)
+		for k, v := range podLabels {
+			setLabel(&rec, k, v)
+		}
+		for k, v := range labels {
+			setLabel(&rec, k, v)
+		}
+		if parse != nil {
+			parseJoined(parse, line, &rec)
+		}
+		return emit(rec, cp)
+	}
+	return &lineOut{dec: dec, j: j, record: record}, nil
+}
+
+// tailer is the read state of one open file: the handle, a buffered reader over it,
+// the offset just past the last complete line, and any trailing text without a newline yet.
+// inode and head (the first consumed bytes) identify the file for checkpoints.
+type tailer struct {
+	file    *os.File
+	r       *bufio.Reader
+	offset  int64
+	partial string
+	inode   uint64
+	head    []byte
+	fp      string
+}
+
+// open makes file the tailer's current file, reading from the start.
+func (t *tailer) open(file *os.File) error {
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if t.file != nil && t.file != file {
+		t.file.Close()
+	}
+	t.file = file
+	t.r.Reset(file)
+	t.offset = 0
+	t.partial = ""
+	t.inode = checkpoint.Inode(fi)
+	t.head = t.head[:0]
+	t.fp = checkpoint.Fingerprint(nil)
+	return nil
+}
+
+// consumed advances the offset past line and extends the fingerprinted head if still short.
+func (t *tailer) consumed(line string) {
+	t.offset += int64(len(line))
+	if n := checkpoint.FingerprintSize - len(t.head); n > 0 {
+		if n > len(line) {
+			n = len(line)
+		}
+		t.head = append(t.head, line[:n]...)
+		t.fp = checkpoint.Fingerprint(t.head)
+	}
+}
+
+func (t *tailer) checkpoint() checkpoint.Checkpoint {
+	return checkpoint.Checkpoint{Offset: t.offset, Inode: t.inode, Fingerprint: t.fp}
+}
+
+// readLines emits every complete line available until EOF. A trailing fragment without
+// a newline is kept in t.partial so a line being written is not split in two.
+func (t *tailer) readLines(emit func(string, checkpoint.Checkpoint) bool) (ok bool, err error) {
+	for {
+		chunk, err := t.r.ReadString('\n')
+		if err == nil {
+			line := t.partial + chunk
+			t.partial = ""
+			t.consumed(line)
+			if !emit(line, t.checkpoint()) {
+				return false, nil
+			}
+			continue
+		}
+		t.partial += chunk
+		if err == io.EOF {
+			return true, nil
+		}
+		return false, err
+	}
+}
+
+// flushPartial emits the pending fragment as a final line (end of a read-once file or a rotated file).
+func (t *tailer) flushPartial(emit func(string, checkpoint.Checkpoint) bool) bool {
+	if t.partial == "" {
+		return true
+	}
+	line := t.partial
+	t.partial = ""
+	t.consumed(line)
+	return emit(line, t.checkpoint())
+}
+
+// resume seeks t past the stored checkpoint when it still describes the open file.
+// A different inode, a changed fingerprint or a file shorter than the offset means the
+// file was rotated or truncated since. Otherwise, the file may be one h knows under another
+// name; its position is then taken over and stored for path. Failing both, reading starts
+// from the beginning.
+func (f *FileSource) resume(t *tailer, path string, h *handoff) error {
+	if f.Checkpoints != nil && !f.Rescan {
+		if cp, ok := f.Checkpoints.Get(f.ID(), path); ok {
+			ok, err := t.matches(cp)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return t.seek(cp)
 			}
 		}
-		if err := scanner.Err(); err != nil {
-			errCh <- err
-			return
+	}
+	cp, ok, err := h.take(t)
+	if err != nil || !ok {
+		return err
+	}
+	if err := t.seek(cp); err != nil {
+		return err
+	}
+	if f.Checkpoints != nil {
+		f.Checkpoints.Set(f.ID(), path, cp)
+	}
+	return nil
+}
+
+// matches reports whether cp is a position in the open file: same inode where known, at
+// least cp.Offset bytes long, and the same leading bytes.
+func (t *tailer) matches(cp checkpoint.Checkpoint) (bool, error) {
+	if cp.Offset <= 0 || (cp.Inode != 0 && t.inode != 0 && cp.Inode != t.inode) {
+		return false, nil
+	}
+	fi, err := t.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() < cp.Offset {
+		return false, nil
+	}
+	head, err := t.readHead(cp.Offset)
+	if err != nil {
+		return false, err
+	}
+	return checkpoint.Fingerprint(head) == cp.Fingerprint, nil
+}
+
+// readHead returns the first min(offset, FingerprintSize) bytes of the open file.
+func (t *tailer) readHead(offset int64) ([]byte, error) {
+	n := offset
+	if n > checkpoint.FingerprintSize {
+		n = checkpoint.FingerprintSize
+	}
+	head := make([]byte, n)
+	if _, err := t.file.ReadAt(head, 0); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+// seek moves t to cp, which must match the open file.
+func (t *tailer) seek(cp checkpoint.Checkpoint) error {
+	head, err := t.readHead(cp.Offset)
+	if err != nil {
+		return err
+	}
+	if _, err := t.file.Seek(cp.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	t.r.Reset(t.file)
+	t.offset = cp.Offset
+	t.head = head
+	t.fp = cp.Fingerprint
+	return nil
+}
+
+func (f *FileSource) follow(ctx context.Context, path string, multi bool, h *handoff, t *tailer, out *lineOut) error {
+	poll := f.Poll
+	if poll <= 0 {
+		poll = DefaultTailPoll
+	}
+	for {
+		ok, err := t.readLines(out.line)
+		if err != nil || !ok {
+			return err
 		}
 		if !f.Tail {
-			return
+			if t.flushPartial(out.line) {
+				out.flush()
+			}
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(poll):
+		}
+		if !out.idle() {
+			return nil
+		}
+		cur, err := t.file.Stat()
+		if err != nil {
+			return err
 		}
-		// Simple tail: re-read from end (in a real impl use fsnotify + seek)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
+		atPath, err := os.Stat(path)
+		if err != nil {
+			if multi && os.IsNotExist(err) {
+				// Removed from a glob/directory source: finish the old handle and stop.
+				if ok, err := t.readLines(out.line); err != nil || !ok {
+					return err
+				}
+				if t.flushPartial(out.line) {
+					out.flush()
+				}
+				return nil
 			}
-			// Read-once only; tail (follow) can be added later with fsnotify
-			return
+			// Rotated away and not yet recreated: keep reading the old handle.
+			continue
 		}
-	}()
-	return recCh, errCh
+		if !os.SameFile(cur, atPath) {
+			// Rename/create rotation: drain what was written to the old file before switching.
+			if ok, err := t.readLines(out.line); err != nil || !ok {
+				return err
+			}
+			if !t.flushPartial(out.line) || !out.flush() {
+				return nil
+			}
+			next, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			old := t.checkpoint()
+			if err := t.open(next); err != nil {
+				next.Close()
+				return err
+			}
+			h.leave(old) // before closed, so a follower of the new name finds it
+			h.closed(old.Inode)
+			h.opened(t.inode)
+			continue
+		}
+		if atPath.Size() < t.offset+int64(len(t.partial)) {
+			// copytruncate: the file was truncated in place, start again from the beginning.
+			if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			if err := t.open(t.file); err != nil {
+				return err
+			}
+		}
+	}
 }
@@ -2,11 +2,14 @@ package source
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/ailert/ailert/internal/checkpoint"
 	"github.com/ailert/ailert/internal/testutil"
+	"github.com/ailert/ailert/internal/types"
 )
 
 func TestFileSource_ReadFile(t *testing.T) {
@@ -104,3 +107,356 @@ func TestFileSource_SkipsEmptyLines(t *testing.T) {
 		t.Fatalf("expected 3 non-empty lines, got %d: %v", len(recs), recs)
 	}
 }
+
+// collect reads records from recCh until n are received or the timeout expires.
+func collect(t *testing.T, recCh <-chan types.Record, n int, timeout time.Duration) []string {
+	t.Helper()
+	var out []string
+	deadline := time.After(timeout)
+	for len(out) < n {
+		select {
+		case rec, ok := <-recCh:
+			if !ok {
+				return out
+			}
+			out = append(out, rec.Message)
+		case <-deadline:
+			t.Fatalf("timed out after %d of %d records: %v", len(out), n, out)
+		}
+	}
+	return out
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, l := range lines {
+		if _, err := f.WriteString(l + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileSource_TailFollowsAppends(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"ERROR first"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{Path: logPath, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	if got := collect(t, recCh, 1, 2*time.Second); got[0] != "ERROR first" {
+		t.Fatalf("got %v", got)
+	}
+	// A line written in two parts is emitted once, whole.
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("WARN sec")
+	time.Sleep(50 * time.Millisecond)
+	f.WriteString("ond\n")
+	f.Close()
+	if got := collect(t, recCh, 1, 2*time.Second); got[0] != "WARN second" {
+		t.Fatalf("got %v", got)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestFileSource_TailRotation(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"one"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{Path: logPath, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	collect(t, recCh, 1, 2*time.Second)
+	// Written after our last read but before rotation: must not be lost.
+	appendLines(t, logPath, "two")
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logPath, "three")
+	got := collect(t, recCh, 2, 2*time.Second)
+	if got[0] != "two" || got[1] != "three" {
+		t.Errorf("got %v, want [two three]", got)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestFileSource_TailTruncate(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"a long first line", "a long second line"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{Path: logPath, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	collect(t, recCh, 2, 2*time.Second)
+	if err := os.Truncate(logPath, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	appendLines(t, logPath, "after")
+	if got := collect(t, recCh, 1, 2*time.Second); got[0] != "after" {
+		t.Errorf("got %v, want [after]", got)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestFileSource_TailStopsOnCancel(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &FileSource{Path: logPath, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	cancel()
+	select {
+	case _, ok := <-recCh:
+		if ok {
+			t.Error("unexpected record")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream did not close after cancel")
+	}
+}
+
+// readAcked reads the whole file once, acking every record like the run pipeline does.
+func readAcked(t *testing.T, src *FileSource) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	recCh, errCh := src.Stream(ctx)
+	var out []string
+	for rec := range recCh {
+		out = append(out, rec.Message)
+		if rec.Ack != nil {
+			rec.Ack()
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestFileSource_CheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"one", "two"}); err != nil {
+		t.Fatal(err)
+	}
+	cps := checkpoint.New(filepath.Join(dir, "checkpoints.json"))
+	if got := readAcked(t, &FileSource{Path: logPath, SourceID: "app", Checkpoints: cps}); len(got) != 2 {
+		t.Fatalf("first read: %v", got)
+	}
+	if err := cps.Save(); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logPath, "three")
+
+	// New process: reload checkpoints and only see the appended line.
+	cps = checkpoint.New(filepath.Join(dir, "checkpoints.json"))
+	if err := cps.Load(); err != nil {
+		t.Fatal(err)
+	}
+	got := readAcked(t, &FileSource{Path: logPath, SourceID: "app", Checkpoints: cps})
+	if len(got) != 1 || got[0] != "three" {
+		t.Errorf("resumed read = %v, want [three]", got)
+	}
+	got = readAcked(t, &FileSource{Path: logPath, SourceID: "app", Checkpoints: cps, Rescan: true})
+	if len(got) != 3 {
+		t.Errorf("rescan read = %v, want all 3 lines", got)
+	}
+}
+
+func TestFileSource_CheckpointReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"old one", "old two"}); err != nil {
+		t.Fatal(err)
+	}
+	cps := checkpoint.New("")
+	readAcked(t, &FileSource{Path: logPath, SourceID: "app", Checkpoints: cps})
+	// Rotated while we were down: a different, longer file now sits at the path.
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.WriteLogLines(logPath, []string{"new one", "new two", "new three"}); err != nil {
+		t.Fatal(err)
+	}
+	got := readAcked(t, &FileSource{Path: logPath, SourceID: "app", Checkpoints: cps})
+	if len(got) != 3 || got[0] != "new one" {
+		t.Errorf("read after rotation = %v, want the whole new file", got)
+	}
+}
+
+func TestFileSource_GlobReadOnce(t *testing.T) {
+	dir := t.TempDir()
+	if err := testutil.WriteLogLines(filepath.Join(dir, "a.log"), []string{"from a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.WriteLogLines(filepath.Join(dir, "b.log"), []string{"from b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.WriteLogLines(filepath.Join(dir, "c.txt"), []string{"ignored"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	src := &FileSource{Path: filepath.Join(dir, "*.log"), SourceID: "test"}
+	recCh, errCh := src.Stream(ctx)
+	got := map[string]string{}
+	for rec := range recCh {
+		got[rec.Message] = rec.Labels["file"]
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"from a": filepath.Join(dir, "a.log"),
+		"from b": filepath.Join(dir, "b.log"),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for msg, file := range want {
+		if got[msg] != file {
+			t.Errorf("record %q: file label = %q, want %q", msg, got[msg], file)
+		}
+	}
+}
+
+func TestFileSource_DirectoryDiscoversNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := testutil.WriteLogLines(filepath.Join(dir, "first.log"), []string{"first"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{Path: dir, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond, Discover: 20 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	collect(t, recCh, 1, 2*time.Second)
+	newPath := filepath.Join(dir, "second.log")
+	appendLines(t, newPath, "second")
+	select {
+	case rec := <-recCh:
+		if rec.Message != "second" || rec.Labels["file"] != newPath {
+			t.Errorf("got %q from %q", rec.Message, rec.Labels["file"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("new file was not discovered")
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestFileSource_DirectoryRenameRotation(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"line one", "line two"}); err != nil {
+		t.Fatal(err)
+	}
+	cps := checkpoint.New("")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{Path: dir, SourceID: "test", Tail: true, Poll: 10 * time.Millisecond, Discover: 20 * time.Millisecond, Checkpoints: cps}
+	recCh, _ := src.Stream(ctx)
+	collect(t, recCh, 2, 2*time.Second)
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logPath, "line three")
+	if got := collect(t, recCh, 1, 2*time.Second); got[0] != "line three" {
+		t.Fatalf("got %v, want [line three]", got)
+	}
+	// Several discovery rounds: the renamed file must not be read again.
+	select {
+	case rec := <-recCh:
+		t.Errorf("unexpected record %q from %q", rec.Message, rec.Labels["file"])
+	case <-time.After(200 * time.Millisecond):
+	}
+	// Lines still written to the renamed file are read from where the old name left off.
+	appendLines(t, logPath+".1", "late line")
+	select {
+	case rec := <-recCh:
+		if rec.Message != "late line" || rec.Labels["file"] != logPath+".1" {
+			t.Errorf("got %q from %q", rec.Message, rec.Labels["file"])
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("renamed file is not followed")
+	}
+	if cp, ok := cps.Get("test", logPath+".1"); !ok || cp.Offset == 0 {
+		t.Errorf("checkpoint of the renamed file = %+v, %v", cp, ok)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestFileSource_GlobRenamedWhileStopped(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"line one", "line two"}); err != nil {
+		t.Fatal(err)
+	}
+	cps := checkpoint.New("")
+	readAcked(t, &FileSource{Path: filepath.Join(dir, "app.log*"), SourceID: "app", Checkpoints: cps})
+	if err := os.Rename(logPath, logPath+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.WriteLogLines(logPath, []string{"line three"}); err != nil {
+		t.Fatal(err)
+	}
+	got := readAcked(t, &FileSource{Path: filepath.Join(dir, "app.log*"), SourceID: "app", Checkpoints: cps})
+	if len(got) != 1 || got[0] != "line three" {
+		t.Errorf("read after rename = %v, want [line three]", got)
+	}
+}
+
+func TestFileSource_JSONFormat(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.json")
+	lines := []string{`{"level":"error","msg":"db down","time":"2024-05-01T10:00:00Z"}`}
+	if err := testutil.WriteLogLines(logPath, lines); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	src := &FileSource{Path: logPath, SourceID: "test", Format: FormatMapping{Type: "json"}}
+	recCh, _ := src.Stream(ctx)
+	var recs []types.Record
+	for rec := range recCh {
+		recs = append(recs, rec)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("got %d records", len(recs))
+	}
+	if recs[0].Message != "db down" || recs[0].Level != types.LevelError || recs[0].Timestamp.Year() != 2024 {
+		t.Errorf("rec = %+v", recs[0])
+	}
+	if recs[0].Labels["file"] != logPath {
+		t.Errorf("file label = %q", recs[0].Labels["file"])
+	}
+}
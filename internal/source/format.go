@@ -0,0 +1,245 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// Default field names tried, in order, when the mapping does not name one.
+var (
+	defaultTimestampFields = []string{"timestamp", "time", "ts", "@timestamp"}
+	defaultLevelFields     = []string{"level", "severity", "lvl"}
+	defaultMessageFields   = []string{"message", "msg"}
+)
+
+// lineParser decodes one raw line into rec. rec arrives with Message set to the line and
+// Timestamp set to the read time; a line that does not parse is left as is.
+type lineParser func(line string, rec *types.Record)
+
+// parser returns the line parser for the mapping's Type, or nil for raw lines.
+func (m *FormatMapping) parser() (lineParser, error) {
+	switch m.Type {
+	case "", "raw":
+		return nil, nil
+	case "json":
+		return m.parseJSON, nil
+	case "logfmt":
+		return m.parseLogfmt, nil
+	case "regex":
+		return m.regexParser()
+	default:
+		return nil, fmt.Errorf("format: unknown type %q", m.Type)
+	}
+}
+
+// parseJSON decodes a JSON object line. Field names may use dots to reach nested objects
+// (e.g. "kubernetes.pod_name").
+func (m *FormatMapping) parseJSON(line string, rec *types.Record) {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	var obj map[string]any
+	if err := dec.Decode(&obj); err != nil {
+		return
+	}
+	m.apply(func(name string) (string, bool) {
+		var cur any = obj
+		for _, part := range strings.Split(name, ".") {
+			o, ok := cur.(map[string]any)
+			if !ok {
+				return "", false
+			}
+			if cur, ok = o[part]; !ok {
+				return "", false
+			}
+		}
+		return jsonString(cur)
+	}, rec)
+}
+
+func jsonString(v any) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+}
+
+// parseLogfmt decodes a key=value line; values may be double-quoted with backslash escapes.
+func (m *FormatMapping) parseLogfmt(line string, rec *types.Record) {
+	fields := parseLogfmt(line)
+	if len(fields) == 0 {
+		return
+	}
+	m.apply(func(name string) (string, bool) {
+		v, ok := fields[name]
+		return v, ok
+	}, rec)
+}
+
+func parseLogfmt(line string) map[string]string {
+	fields := make(map[string]string)
+	i := 0
+	for i < len(line) {
+		for i < len(line) && line[i] == ' ' {
+			i++
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] == ' ' {
+			if key != "" {
+				fields[key] = "true"
+			}
+			continue
+		}
+		i++ // '='
+		if i < len(line) && line[i] == '"' {
+			var b bytes.Buffer
+			i++
+			for i < len(line) && line[i] != '"' {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(line[i])
+					}
+				} else {
+					b.WriteByte(line[i])
+				}
+				i++
+			}
+			i++ // closing quote
+			fields[key] = b.String()
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' {
+			i++
+		}
+		if key != "" {
+			fields[key] = line[start:i]
+		}
+	}
+	return fields
+}
+
+// apply maps decoded fields onto rec using the mapping (or the default field names).
+func (m *FormatMapping) apply(field func(name string) (string, bool), rec *types.Record) {
+	if v, ok := lookup(field, m.MessageField, defaultMessageFields); ok {
+		rec.Message = v
+	}
+	if v, ok := lookup(field, m.LevelField, defaultLevelFields); ok {
+		rec.Level = parseLevelAlias(v)
+	}
+	if v, ok := lookup(field, m.TimestampField, defaultTimestampFields); ok {
+		if ts, ok := parseTimestamp(v, m.TimestampLayout); ok {
+			rec.Timestamp = ts
+		}
+	}
+	for raw, label := range m.LabelFields {
+		if v, ok := field(raw); ok {
+			if rec.Labels == nil {
+				rec.Labels = make(map[string]string)
+			}
+			rec.Labels[label] = v
+		}
+	}
+}
+
+func lookup(field func(string) (string, bool), name string, defaults []string) (string, bool) {
+	if name != "" {
+		return field(name)
+	}
+	for _, d := range defaults {
+		if v, ok := field(d); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// parseLevelAlias is types.ParseLevel plus the spellings common in structured logs
+// (warning, err, fatal, critical, trace, ...). Unknown strings map to LevelUnknown so the
+// engine falls back to detecting the level from the message.
+func parseLevelAlias(s string) types.Level {
+	if l := types.ParseLevel(s); l != types.LevelUnknown {
+		return l
+	}
+	switch strings.TrimRight(strings.ToLower(strings.TrimSpace(s)), "0123456789") {
+	case "trace", "dbg", "d":
+		return types.LevelDebug
+	case "information", "notice", "informational", "i":
+		return types.LevelInfo
+	case "warning", "wrn", "w":
+		return types.LevelWarn
+	case "err", "eror", "fatal", "critical", "crit", "panic", "alert", "emerg", "emergency", "severe", "e", "f":
+		return types.LevelError
+	}
+	return types.LevelUnknown
+}
+
+// parseTimestamp parses s with layout, or when layout is empty as RFC 3339 or a Unix epoch
+// number in seconds, milliseconds, microseconds or nanoseconds (picked by magnitude).
+// Layouts without a zone are read as local time; layouts without a year (syslog) get the
+// current year, or the previous one if that would put the time more than a day ahead.
+func parseTimestamp(s, layout string) (time.Time, bool) {
+	if layout != "" {
+		ts, err := time.ParseInLocation(layout, s, time.Local)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if ts.Year() == 0 {
+			now := time.Now()
+			ts = ts.AddDate(now.Year(), 0, 0)
+			if ts.After(now.Add(24 * time.Hour)) {
+				ts = ts.AddDate(-1, 0, 0)
+			}
+		}
+		return ts, true
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, true
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+		switch {
+		case n >= 1e18:
+			return time.Unix(0, n), true
+		case n >= 1e15:
+			return time.UnixMicro(n), true
+		case n >= 1e12:
+			return time.UnixMilli(n), true
+		default:
+			return time.Unix(n, 0), true
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
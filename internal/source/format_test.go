@@ -0,0 +1,98 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func parseWith(t *testing.T, m FormatMapping, line string) types.Record {
+	t.Helper()
+	parse, err := m.parser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := types.Record{Message: line}
+	if parse != nil {
+		parse(line, &rec)
+	}
+	return rec
+}
+
+func TestFormatMapping_JSON(t *testing.T) {
+	m := FormatMapping{
+		Type:        "json",
+		LabelFields: map[string]string{"svc": "service", "k8s.pod": "pod"},
+	}
+	rec := parseWith(t, m, `{"ts":"2024-05-01T10:00:00Z","level":"warning","msg":"disk almost full","svc":"api","k8s":{"pod":"api-1"}}`)
+	if rec.Message != "disk almost full" {
+		t.Errorf("Message = %q", rec.Message)
+	}
+	if rec.Level != types.LevelWarn {
+		t.Errorf("Level = %v, want WARN", rec.Level)
+	}
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC); !rec.Timestamp.Equal(want) {
+		t.Errorf("Timestamp = %v, want %v", rec.Timestamp, want)
+	}
+	if rec.Labels["service"] != "api" || rec.Labels["pod"] != "api-1" {
+		t.Errorf("Labels = %v", rec.Labels)
+	}
+}
+
+func TestFormatMapping_JSONCustomFields(t *testing.T) {
+	m := FormatMapping{
+		Type:            "json",
+		TimestampField:  "when",
+		TimestampLayout: "2006-01-02 15:04:05",
+		LevelField:      "sev",
+		MessageField:    "text",
+	}
+	rec := parseWith(t, m, `{"when":"2024-05-01 10:00:00","sev":"ERROR","text":"boom","msg":"not this"}`)
+	if rec.Message != "boom" || rec.Level != types.LevelError {
+		t.Errorf("got %q %v", rec.Message, rec.Level)
+	}
+	if rec.Timestamp.Hour() != 10 {
+		t.Errorf("Timestamp = %v", rec.Timestamp)
+	}
+}
+
+func TestFormatMapping_JSONInvalidKeepsLine(t *testing.T) {
+	rec := parseWith(t, FormatMapping{Type: "json"}, "ERROR not json")
+	if rec.Message != "ERROR not json" || rec.Level != types.LevelUnknown {
+		t.Errorf("got %q %v", rec.Message, rec.Level)
+	}
+}
+
+func TestFormatMapping_Logfmt(t *testing.T) {
+	m := FormatMapping{Type: "logfmt", LabelFields: map[string]string{"component": "component"}}
+	rec := parseWith(t, m, `ts=1714557600 level=err msg="connection \"db\" refused" component=store retry`)
+	if rec.Message != `connection "db" refused` {
+		t.Errorf("Message = %q", rec.Message)
+	}
+	if rec.Level != types.LevelError {
+		t.Errorf("Level = %v", rec.Level)
+	}
+	if rec.Timestamp.Unix() != 1714557600 {
+		t.Errorf("Timestamp = %v", rec.Timestamp)
+	}
+	if rec.Labels["component"] != "store" {
+		t.Errorf("Labels = %v", rec.Labels)
+	}
+}
+
+func TestFormatMapping_UnknownType(t *testing.T) {
+	m := FormatMapping{Type: "xml"}
+	if _, err := m.parser(); err == nil {
+		t.Error("expected error for unknown format type")
+	}
+}
+
+func TestParseTimestamp_Epoch(t *testing.T) {
+	for _, s := range []string{"1714557600", "1714557600000", "1714557600000000", "1714557600000000000", "1714557600.5"} {
+		ts, ok := parseTimestamp(s, "")
+		if !ok || ts.Unix() != 1714557600 {
+			t.Errorf("parseTimestamp(%q) = %v, %v", s, ts, ok)
+		}
+	}
+}
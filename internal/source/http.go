@@ -3,20 +3,29 @@ package source
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/ailert/ailert/internal/checkpoint"
 	"github.com/ailert/ailert/internal/types"
 )
 
 // HTTPSource fetches a URL (GET) and emits each non-empty line as a Record.
-// Useful for log URLs or simple text endpoints.
+// Useful for log URLs or simple text endpoints. Format optionally decodes structured lines
+// and Multiline joins stack traces into one record. With Interval set the URL is fetched
+// again on that schedule until ctx is done; each poll emits only the records that were not
+// in the previous response (compared as a multiset of lines), so a static or slowly
+// changing endpoint does not count its lines again.
 type HTTPSource struct {
-	URL      string
-	SourceID string
-	Client   *http.Client
+	URL       string
+	SourceID  string
+	Client    *http.Client
+	Format    FormatMapping
+	Multiline *MultilineRule // optional; join continuation lines into one record
+	Interval  time.Duration  // re-fetch this often; 0 fetches once
 }
 
 // ID implements Source.
@@ -27,54 +36,98 @@ func (h *HTTPSource) ID() string {
 	return "http:" + h.URL
 }
 
-// Stream implements Source. One fetch, then closes.
+// Stream implements Source. One fetch, then closes, unless Interval is set.
 func (h *HTTPSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
 	recCh := make(chan types.Record, 64)
 	errCh := make(chan error, 1)
 	go func() {
 		defer close(recCh)
 		defer close(errCh)
-		client := h.Client
-		if client == nil {
-			client = &http.Client{Timeout: 15 * time.Second}
-		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+		parse, err := h.Format.parser()
 		if err != nil {
 			errCh <- err
 			return
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			errCh <- err
-			return
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			errCh <- fmt.Errorf("GET %s: %s", h.URL, resp.Status)
-			return
-		}
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
+		if _, err := h.Multiline.newJoiner(); err != nil {
 			errCh <- err
 			return
 		}
-		for _, line := range strings.Split(string(body), "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			select {
-			case <-ctx.Done():
-				return
-			default:
-			}
-			recCh <- types.Record{
-				Timestamp: time.Now(),
-				Level:     types.LevelUnknown,
-				Message:   line,
-				SourceID:  h.ID(),
+		var prev map[uint64]int // records of the previous response, by hash
+		err = poll(ctx, h.ID(), h.Interval, func(ctx context.Context) error {
+			next, err := h.fetch(ctx, parse, prev, recCh)
+			if err == nil {
+				prev = next
 			}
+			return err
+		})
+		if err != nil && ctx.Err() == nil {
+			errCh <- err
 		}
 	}()
 	return recCh, errCh
 }
+
+// fetch GETs the URL once and emits its records, skipping as many copies of each as prev
+// holds. It returns the records of this response for the next call.
+func (h *HTTPSource) fetch(ctx context.Context, parse lineParser, prev map[uint64]int, recCh chan<- types.Record) (map[uint64]int, error) {
+	j, err := h.Multiline.newJoiner()
+	if err != nil {
+		return nil, err
+	}
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", h.URL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint64]int)
+	record := func(line string, _ checkpoint.Checkpoint) bool {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return true
+		}
+		sum := fnv.New64a()
+		sum.Write([]byte(line))
+		k := sum.Sum64()
+		if seen[k]++; seen[k] <= prev[k] {
+			return true
+		}
+		rec := types.Record{
+			Timestamp: time.Now(),
+			Level:     types.LevelUnknown,
+			Message:   line,
+			SourceID:  h.ID(),
+		}
+		if parse != nil {
+			parseJoined(parse, line, &rec)
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case recCh <- rec:
+			return true
+		}
+	}
+	out := &lineOut{j: j, record: record}
+	for _, line := range strings.Split(string(body), "\n") {
+		if !out.line(line, checkpoint.Checkpoint{}) {
+			return nil, ctx.Err()
+		}
+	}
+	out.flush()
+	return seen, nil
+}
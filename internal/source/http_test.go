@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -38,3 +40,30 @@ func TestHTTPSource_ID(t *testing.T) {
 		t.Errorf("ID() = %q", got)
 	}
 }
+
+// With Interval, lines already in the previous response are not emitted again.
+func TestHTTPSource_IntervalNewLinesOnly(t *testing.T) {
+	bodies := []string{"a\nb\n", "a\nb\n", "a\nb\nc\nb\n", "b\nc\nb\nd\n"}
+	var mu sync.Mutex
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write([]byte(bodies[min(n, len(bodies)-1)]))
+		n++
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &HTTPSource{URL: srv.URL, Interval: 10 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	want := []string{"a", "b", "c", "b", "d"}
+	if got := collect(t, recCh, len(want), 2*time.Second); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	select {
+	case rec := <-recCh:
+		t.Errorf("unchanged response emitted %q again", rec.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,226 @@
+package source
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// maxPushBody bounds one push request body (after decompression).
+const maxPushBody = 16 << 20
+
+// Push endpoints served by HTTPPushSource.
+const (
+	PushPathNDJSON = "/ingest"
+	PushPathLoki   = "/loki/api/v1/push"
+)
+
+// HTTPPushSource starts an HTTP listener that accepts pushed logs:
+//   - POST /ingest: newline-delimited JSON, one record per line. Fields follow Format when it
+//     is a json mapping, else the defaults (message/msg, level, timestamp/time/ts); a "labels"
+//     object is copied into Record.Labels.
+//   - POST /loki/api/v1/push: the Loki push API JSON body. Stream labels become Record.Labels,
+//     the nanosecond timestamp becomes Record.Timestamp, and the line is the message (decoded
+//     through Format when set). A "level" or "detected_level" label sets the Level.
+//
+// Bodies may be gzip-compressed (Content-Encoding: gzip). Accepted pushes get 204 No Content.
+type HTTPPushSource struct {
+	Addr     string // listen address, e.g. ":3100"
+	SourceID string
+	Format   FormatMapping
+}
+
+// ID implements Source.
+func (h *HTTPPushSource) ID() string {
+	if h.SourceID != "" {
+		return h.SourceID
+	}
+	return "http_push:" + h.Addr
+}
+
+// Stream implements Source. Serves until ctx is done.
+func (h *HTTPPushSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		parse, err := h.Format.parser()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		ln, err := net.Listen("tcp", h.Addr)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		srv := &http.Server{Handler: h.handler(ctx, parse, recCh)}
+		done := make(chan error, 1)
+		go func() { done <- srv.Serve(ln) }()
+		select {
+		case <-ctx.Done():
+		case err := <-done:
+			if !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+			return
+		}
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutCtx)
+	}()
+	return recCh, errCh
+}
+
+func (h *HTTPPushSource) handler(ctx context.Context, parse lineParser, recCh chan<- types.Record) http.Handler {
+	emit := func(r *http.Request, rec types.Record) bool {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-r.Context().Done():
+			return false
+		case recCh <- rec:
+			return true
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(PushPathNDJSON, func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pushBody(w, r)
+		if !ok {
+			return
+		}
+		defer body.Close()
+		mapping := h.Format
+		if mapping.Type != "json" {
+			mapping = FormatMapping{Type: "json"}
+		}
+		sc := bufio.NewScanner(body)
+		sc.Buffer(make([]byte, 64*1024), maxPushBody)
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" {
+				continue
+			}
+			rec := types.Record{Timestamp: time.Now(), Level: types.LevelUnknown, Message: line, SourceID: h.ID()}
+			mapping.parseJSON(line, &rec)
+			var extra struct {
+				Labels map[string]string `json:"labels"`
+			}
+			if json.Unmarshal([]byte(line), &extra) == nil {
+				for k, v := range extra.Labels {
+					setLabel(&rec, k, v)
+				}
+			}
+			if !emit(r, rec) {
+				http.Error(w, "shutting down", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc(PushPathLoki, func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pushBody(w, r)
+		if !ok {
+			return
+		}
+		defer body.Close()
+		if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/json") {
+			http.Error(w, "only application/json Loki pushes are supported", http.StatusUnsupportedMediaType)
+			return
+		}
+		var push lokiPush
+		if err := json.NewDecoder(body).Decode(&push); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, st := range push.Streams {
+			for _, v := range st.Values {
+				rec, ok := lokiRecord(st.Stream, v, parse, h.ID())
+				if !ok {
+					continue
+				}
+				if !emit(r, rec) {
+					http.Error(w, "shutting down", http.StatusServiceUnavailable)
+					return
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// pushBody checks the method and returns the (decompressed, size-limited) request body.
+func pushBody(w http.ResponseWriter, r *http.Request) (io.ReadCloser, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body := http.MaxBytesReader(w, r.Body, maxPushBody)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(zr, maxPushBody), body}, true
+	}
+	return body, true
+}
+
+// lokiPush is the JSON body of the Loki push API (also returned by query_range for streams).
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][]any           `json:"values"` // [ "<unix ns>", "<line>" (, {structured metadata}) ]
+}
+
+// lokiRecord converts one Loki stream entry into a Record.
+func lokiRecord(labels map[string]string, v []any, parse lineParser, sourceID string) (types.Record, bool) {
+	if len(v) < 2 {
+		return types.Record{}, false
+	}
+	tsStr, _ := v[0].(string)
+	line, ok := v[1].(string)
+	if !ok || strings.TrimSpace(line) == "" {
+		return types.Record{}, false
+	}
+	rec := types.Record{Timestamp: time.Now(), Level: types.LevelUnknown, Message: line, SourceID: sourceID}
+	if ns, err := strconv.ParseInt(tsStr, 10, 64); err == nil {
+		rec.Timestamp = time.Unix(0, ns)
+	}
+	for k, val := range labels {
+		setLabel(&rec, k, val)
+	}
+	if lvl, ok := labels["level"]; ok {
+		rec.Level = parseLevelAlias(lvl)
+	} else if lvl, ok := labels["detected_level"]; ok {
+		rec.Level = parseLevelAlias(lvl)
+	}
+	if parse != nil {
+		parse(line, &rec)
+	}
+	return rec, true
+}
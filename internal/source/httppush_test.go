@@ -0,0 +1,121 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// postRetry posts body until the listener is up and returns the response status.
+func postRetry(t *testing.T, url, contentEncoding string, body []byte) int {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHTTPPushSource_NDJSON(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &HTTPPushSource{Addr: addr, SourceID: "push"}
+	recCh, _ := src.Stream(ctx)
+	body := []byte(`{"timestamp":"2024-05-01T10:00:00Z","level":"error","message":"db down","labels":{"app":"api"}}
+{"msg":"started","level":"info"}
+`)
+	if code := postRetry(t, "http://"+addr+PushPathNDJSON, "", body); code != http.StatusNoContent {
+		t.Fatalf("status = %d", code)
+	}
+	var recs []types.Record
+	for len(recs) < 2 {
+		select {
+		case rec := <-recCh:
+			recs = append(recs, rec)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d records", len(recs))
+		}
+	}
+	if recs[0].Message != "db down" || recs[0].Level != types.LevelError || recs[0].Labels["app"] != "api" || recs[0].Timestamp.Year() != 2024 {
+		t.Errorf("rec 0 = %+v", recs[0])
+	}
+	if recs[1].Message != "started" || recs[1].Level != types.LevelInfo || recs[1].SourceID != "push" {
+		t.Errorf("rec 1 = %+v", recs[1])
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestHTTPPushSource_LokiGzip(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &HTTPPushSource{Addr: addr}
+	recCh, _ := src.Stream(ctx)
+	body := `{"streams":[{"stream":{"job":"varlogs","level":"warn"},"values":[["1714557600000000000","disk almost full"],["1714557601000000000","disk almost full again",{"trace_id":"abc"}]]}]}`
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(body))
+	zw.Close()
+	if code := postRetry(t, "http://"+addr+PushPathLoki, "gzip", buf.Bytes()); code != http.StatusNoContent {
+		t.Fatalf("status = %d", code)
+	}
+	got := collect(t, recCh, 2, 2*time.Second)
+	if got[0] != "disk almost full" || got[1] != "disk almost full again" {
+		t.Errorf("got %q", got)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestLokiRecord(t *testing.T) {
+	rec, ok := lokiRecord(map[string]string{"app": "api", "detected_level": "error"}, []any{"1714557600000000000", "boom"}, nil, "loki")
+	if !ok {
+		t.Fatal("lokiRecord failed")
+	}
+	if rec.Message != "boom" || rec.Level != types.LevelError || rec.Labels["app"] != "api" || rec.Timestamp.Unix() != 1714557600 {
+		t.Errorf("rec = %+v", rec)
+	}
+	if _, ok := lokiRecord(nil, []any{"1"}, nil, "loki"); ok {
+		t.Error("entry without line should be skipped")
+	}
+}
+
+func TestHTTPPushSource_MethodNotAllowed(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recCh, _ := (&HTTPPushSource{Addr: addr}).Stream(ctx)
+	postRetry(t, "http://"+addr+PushPathNDJSON, "", nil)
+	resp, err := http.Get("http://" + addr + PushPathNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d", resp.StatusCode)
+	}
+	cancel()
+	for range recCh {
+	}
+}
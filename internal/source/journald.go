@@ -0,0 +1,211 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// maxJournalField bounds one binary field of the journal export format.
+const maxJournalField = 16 << 20
+
+// JournaldSource reads systemd journal entries from a file or stdin ("-"), in either
+// `journalctl -o json` form (one JSON object per entry) or the journal export format
+// (`journalctl -o export`: KEY=value lines, entries separated by a blank line); the form is
+// detected from the first byte. MESSAGE becomes the message, PRIORITY the Level,
+// __REALTIME_TIMESTAMP the Timestamp, and _SYSTEMD_UNIT, _HOSTNAME and SYSLOG_IDENTIFIER
+// the unit, host and app labels.
+type JournaldSource struct {
+	Path     string // file path, or "-" for stdin
+	SourceID string
+}
+
+// ID implements Source.
+func (j *JournaldSource) ID() string {
+	if j.SourceID != "" {
+		return j.SourceID
+	}
+	return "journald:" + j.Path
+}
+
+// Stream implements Source. Reads to the end of the input.
+func (j *JournaldSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		var r io.Reader = os.Stdin
+		if j.Path != "-" {
+			f, err := os.Open(j.Path)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			defer f.Close()
+			stop := context.AfterFunc(ctx, func() { f.Close() })
+			defer stop()
+			r = f
+		}
+		emit := func(fields map[string]string) bool {
+			rec, ok := journalRecord(fields, j.ID())
+			if !ok {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case recCh <- rec:
+				return true
+			}
+		}
+		if err := readJournal(bufio.NewReader(r), emit); err != nil && ctx.Err() == nil {
+			errCh <- fmt.Errorf("journald: %w", err)
+		}
+	}()
+	return recCh, errCh
+}
+
+// readJournal decodes entries in JSON or export form and passes each to emit.
+func readJournal(r *bufio.Reader, emit func(map[string]string) bool) error {
+	for {
+		b, err := r.Peek(1)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != '\n' && b[0] != '\r' && b[0] != ' ' && b[0] != '\t' {
+			break
+		}
+		r.ReadByte()
+	}
+	b, _ := r.Peek(1)
+	if b[0] == '{' {
+		return readJournalJSON(r, emit)
+	}
+	return readJournalExport(r, emit)
+}
+
+// readJournalJSON decodes a stream of JSON objects. A field value is a string, an array of
+// bytes (non-UTF-8 data), an array of those (repeated fields; the first is kept) or null.
+func readJournalJSON(r io.Reader, emit func(map[string]string) bool) error {
+	dec := json.NewDecoder(r)
+	for {
+		var raw map[string]json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		fields := make(map[string]string, len(raw))
+		for k, v := range raw {
+			if s, ok := journalJSONValue(v); ok {
+				fields[k] = s
+			}
+		}
+		if !emit(fields) {
+			return nil
+		}
+	}
+}
+
+func journalJSONValue(v json.RawMessage) (string, bool) {
+	var s string
+	if json.Unmarshal(v, &s) == nil {
+		return s, true
+	}
+	var bs []byte
+	var nums []int
+	if json.Unmarshal(v, &nums) == nil {
+		for _, n := range nums {
+			bs = append(bs, byte(n))
+		}
+		return string(bs), true
+	}
+	var multi []json.RawMessage
+	if json.Unmarshal(v, &multi) == nil && len(multi) > 0 {
+		return journalJSONValue(multi[0])
+	}
+	return "", false
+}
+
+// readJournalExport decodes the export format: "KEY=value\n" text fields, binary fields as
+// "KEY\n" followed by a little-endian uint64 length, the data and "\n", and a blank line
+// after each entry.
+func readJournalExport(r *bufio.Reader, emit func(map[string]string) bool) error {
+	fields := make(map[string]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if len(fields) > 0 {
+				if !emit(fields) {
+					return nil
+				}
+				fields = make(map[string]string)
+			}
+		case strings.Contains(line, "="):
+			k, v, _ := strings.Cut(line, "=")
+			if _, dup := fields[k]; !dup {
+				fields[k] = v
+			}
+		case !eof:
+			var size uint64
+			if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+				return fmt.Errorf("field %s: %w", line, err)
+			}
+			if size > maxJournalField {
+				return fmt.Errorf("field %s: %d bytes is too large", line, size)
+			}
+			data := make([]byte, size+1)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return fmt.Errorf("field %s: %w", line, err)
+			}
+			if _, dup := fields[line]; !dup {
+				fields[line] = string(bytes.TrimSuffix(data, []byte("\n")))
+			}
+		}
+		if eof {
+			if len(fields) > 0 {
+				emit(fields)
+			}
+			return nil
+		}
+	}
+}
+
+// journalRecord maps journal fields to a Record; entries without MESSAGE are skipped.
+func journalRecord(fields map[string]string, sourceID string) (types.Record, bool) {
+	msg := strings.TrimSpace(fields["MESSAGE"])
+	if msg == "" {
+		return types.Record{}, false
+	}
+	rec := types.Record{Timestamp: time.Now(), Level: types.LevelUnknown, Message: msg, SourceID: sourceID}
+	if us, err := strconv.ParseInt(fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		rec.Timestamp = time.UnixMicro(us)
+	}
+	if pri, err := strconv.Atoi(fields["PRIORITY"]); err == nil && pri >= 0 && pri <= 7 {
+		rec.Level = syslogSeverityLevel(pri)
+	}
+	setLabel(&rec, "unit", fields["_SYSTEMD_UNIT"])
+	setLabel(&rec, "host", fields["_HOSTNAME"])
+	setLabel(&rec, "app", fields["SYSLOG_IDENTIFIER"])
+	return rec, true
+}
@@ -0,0 +1,73 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func writeJournal(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJournaldSource_JSON(t *testing.T) {
+	path := writeJournal(t, []byte(`{"__REALTIME_TIMESTAMP":"1714557600123456","PRIORITY":"3","_SYSTEMD_UNIT":"nginx.service","_HOSTNAME":"web-1","SYSLOG_IDENTIFIER":"nginx","MESSAGE":"upstream timed out"}
+{"__REALTIME_TIMESTAMP":"1714557601000000","PRIORITY":"6","MESSAGE":[104,105,255]}
+{"__REALTIME_TIMESTAMP":"1714557602000000","PRIORITY":"4","MESSAGE":["first","second"]}
+{"__REALTIME_TIMESTAMP":"1714557603000000","MESSAGE":null}
+`))
+	recs := readAll(t, &JournaldSource{Path: path, SourceID: "journal"})
+	if len(recs) != 3 {
+		t.Fatalf("got %d records: %+v", len(recs), recs)
+	}
+	r := recs[0]
+	if r.Message != "upstream timed out" || r.Level != types.LevelError || r.SourceID != "journal" {
+		t.Errorf("record 0 = %+v", r)
+	}
+	if r.Labels["unit"] != "nginx.service" || r.Labels["host"] != "web-1" || r.Labels["app"] != "nginx" {
+		t.Errorf("labels = %v", r.Labels)
+	}
+	if want := time.UnixMicro(1714557600123456); !r.Timestamp.Equal(want) {
+		t.Errorf("timestamp = %v, want %v", r.Timestamp, want)
+	}
+	if recs[1].Message != "hi\xff" || recs[1].Level != types.LevelInfo {
+		t.Errorf("binary message record = %+v", recs[1])
+	}
+	if recs[2].Message != "first" || recs[2].Level != types.LevelWarn {
+		t.Errorf("repeated field record = %+v", recs[2])
+	}
+}
+
+func TestJournaldSource_Export(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("__REALTIME_TIMESTAMP=1714557600000000\nPRIORITY=2\n_SYSTEMD_UNIT=sshd.service\nMESSAGE=fatal: no hostkey\n\n")
+	buf.WriteString("__REALTIME_TIMESTAMP=1714557601000000\nPRIORITY=7\nMESSAGE\n")
+	msg := "line one\nline two"
+	binary.Write(&buf, binary.LittleEndian, uint64(len(msg)))
+	buf.WriteString(msg + "\n")
+	buf.WriteString("_HOSTNAME=db-1\n\n")
+	buf.WriteString("PRIORITY=5\nMESSAGE=no trailing blank line")
+	recs := readAll(t, &JournaldSource{Path: writeJournal(t, buf.Bytes())})
+	if len(recs) != 3 {
+		t.Fatalf("got %d records: %+v", len(recs), recs)
+	}
+	if recs[0].Message != "fatal: no hostkey" || recs[0].Level != types.LevelError || recs[0].Labels["unit"] != "sshd.service" {
+		t.Errorf("record 0 = %+v", recs[0])
+	}
+	if recs[1].Message != msg || recs[1].Level != types.LevelDebug || recs[1].Labels["host"] != "db-1" {
+		t.Errorf("record 1 = %+v", recs[1])
+	}
+	if recs[2].Message != "no trailing blank line" || recs[2].Level != types.LevelInfo {
+		t.Errorf("record 2 = %+v", recs[2])
+	}
+}
@@ -0,0 +1,141 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/ailert/ailert/internal/metrics"
+	"github.com/ailert/ailert/internal/types"
+)
+
+// kafkaCommitTimeout bounds the final offset commit when the source stops.
+const kafkaCommitTimeout = 5 * time.Second
+
+// KafkaSource consumes Topics as a member of the consumer group Group and emits one Record
+// per message. The message value is the line: Format decodes it (json, logfmt, regex) and
+// the Kafka timestamp is used unless Format sets one; each Record has a "topic" label.
+//
+// Offsets are committed only for processed messages: a record's Ack marks its offset and
+// marked offsets are committed periodically, on rebalance and when the source stops, so a
+// restart resumes after the last processed message. A new group starts at the earliest offset.
+// Fetch errors are counted in metrics.SourceErrors and reported on stderr; the client
+// keeps retrying until ctx is done.
+type KafkaSource struct {
+	Brokers  []string
+	Topics   []string
+	Group    string
+	SourceID string
+	Format   FormatMapping
+	Opts     []kgo.Opt // optional; extra client options (TLS, SASL, ...)
+}
+
+// ID implements Source.
+func (k *KafkaSource) ID() string {
+	if k.SourceID != "" {
+		return k.SourceID
+	}
+	return "kafka:" + strings.Join(k.Topics, ",")
+}
+
+// Stream implements Source. Consumes until ctx is done.
+func (k *KafkaSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		switch {
+		case len(k.Brokers) == 0:
+			errCh <- fmt.Errorf("kafka: brokers are required")
+			return
+		case len(k.Topics) == 0:
+			errCh <- fmt.Errorf("kafka: topics are required")
+			return
+		case k.Group == "":
+			errCh <- fmt.Errorf("kafka: group is required")
+			return
+		}
+		parse, err := k.Format.parser()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		opts := append([]kgo.Opt{
+			kgo.SeedBrokers(k.Brokers...),
+			kgo.ConsumeTopics(k.Topics...),
+			kgo.ConsumerGroup(k.Group),
+			kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+			kgo.AutoCommitMarks(),
+		}, k.Opts...)
+		client, err := kgo.NewClient(opts...)
+		if err != nil {
+			errCh <- fmt.Errorf("kafka: %w", err)
+			return
+		}
+		defer func() {
+			cctx, cancel := context.WithTimeout(context.Background(), kafkaCommitTimeout)
+			defer cancel()
+			if err := client.CommitMarkedOffsets(cctx); err != nil {
+				fmt.Fprintf(os.Stderr, "source %s: commit offsets: %v\n", k.ID(), err)
+			}
+			client.Close()
+		}()
+		k.consume(ctx, client, parse, recCh)
+	}()
+	return recCh, errCh
+}
+
+func (k *KafkaSource) consume(ctx context.Context, client *kgo.Client, parse lineParser, recCh chan<- types.Record) {
+	for {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			return
+		}
+		fetches.EachError(func(topic string, partition int32, err error) {
+			if err == context.Canceled {
+				return
+			}
+			metrics.SourceError(k.ID())
+			fmt.Fprintf(os.Stderr, "source %s: %s[%d]: %v\n", k.ID(), topic, partition, err)
+		})
+		for _, r := range fetches.Records() {
+			rec, ok := k.record(r, parse)
+			if !ok {
+				continue // committed along with the next processed message
+			}
+			rec.Ack = func() { client.MarkCommitRecords(r) }
+			select {
+			case <-ctx.Done():
+				return
+			case recCh <- rec:
+			}
+		}
+	}
+}
+
+// record maps a Kafka message to a Record; messages with an empty value are skipped.
+func (k *KafkaSource) record(r *kgo.Record, parse lineParser) (types.Record, bool) {
+	line := strings.TrimSpace(string(r.Value))
+	if line == "" {
+		return types.Record{}, false
+	}
+	rec := types.Record{
+		Timestamp: r.Timestamp,
+		Level:     types.LevelUnknown,
+		Message:   line,
+		Labels:    map[string]string{"topic": r.Topic},
+		SourceID:  k.ID(),
+	}
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	if parse != nil {
+		parse(line, &rec)
+	}
+	return rec, true
+}
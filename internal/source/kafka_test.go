@@ -0,0 +1,112 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func newKafka(t *testing.T, topic string, partitions int32) []string {
+	t.Helper()
+	c, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(partitions, topic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c.ListenAddrs()
+}
+
+func produce(t *testing.T, brokers []string, topic string, values ...string) {
+	t.Helper()
+	cl, err := kgo.NewClient(kgo.SeedBrokers(brokers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	for _, v := range values {
+		if err := cl.ProduceSync(context.Background(), &kgo.Record{Topic: topic, Value: []byte(v)}).FirstErr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// consumeN reads n records from src, acking the first ack of them, then stops the source.
+func consumeN(t *testing.T, src *KafkaSource, n, ack int) []types.Record {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	recCh, errCh := src.Stream(ctx)
+	var recs []types.Record
+	for len(recs) < n {
+		select {
+		case rec := <-recCh:
+			if len(recs) < ack {
+				rec.Ack()
+			}
+			recs = append(recs, rec)
+		case <-ctx.Done():
+			t.Fatalf("got %d of %d records", len(recs), n)
+		}
+	}
+	cancel()
+	for range recCh {
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func messages(recs []types.Record) []string {
+	var out []string
+	for _, r := range recs {
+		out = append(out, r.Message)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestKafkaSource_CommitsProcessedOffsets(t *testing.T) {
+	brokers := newKafka(t, "logs", 2)
+	var values []string
+	for i := range 6 {
+		values = append(values, fmt.Sprintf(`{"level":"error","msg":"request %d failed"}`, i))
+	}
+	produce(t, brokers, "logs", values...)
+	newSource := func() *KafkaSource {
+		return &KafkaSource{Brokers: brokers, Topics: []string{"logs"}, Group: "ailert", SourceID: "kafka", Format: FormatMapping{Type: "json"},
+			Opts: []kgo.Opt{kgo.FetchMaxWait(100 * time.Millisecond)}}
+	}
+	first := consumeN(t, newSource(), 6, 4)
+	r := first[0]
+	if r.Level != types.LevelError || r.Labels["topic"] != "logs" || r.SourceID != "kafka" || r.Timestamp.IsZero() {
+		t.Errorf("record = %+v", r)
+	}
+	// Only the four processed messages were committed: a restart sees the other two again.
+	second := consumeN(t, newSource(), 2, 2)
+	want := messages(first[4:])
+	if got := messages(second); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after restart got %q, want %q", got, want)
+	}
+}
+
+func TestKafkaSource_Errors(t *testing.T) {
+	for name, src := range map[string]*KafkaSource{
+		"no brokers": {Topics: []string{"logs"}, Group: "g"},
+		"no topics":  {Brokers: []string{"localhost:9092"}, Group: "g"},
+		"no group":   {Brokers: []string{"localhost:9092"}, Topics: []string{"logs"}},
+	} {
+		recCh, errCh := src.Stream(context.Background())
+		for range recCh {
+		}
+		if err := <-errCh; err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
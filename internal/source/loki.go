@@ -0,0 +1,270 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+const (
+	// DefaultLokiSince is how far back the first LogQL query reaches.
+	DefaultLokiSince = time.Hour
+	// DefaultLokiLimit is the number of entries requested per query_range page.
+	DefaultLokiLimit = 1000
+)
+
+// LokiSource runs a LogQL log query against Loki's query_range API and emits one Record per
+// log line, oldest first. The time range is walked in pages of Limit entries with a cursor on
+// the last timestamp seen, so large ranges are read completely (more than Limit entries at
+// one nanosecond are read with larger pages, up to maxLokiLimit). Stream labels become
+// Record.Labels and the entry timestamp becomes Record.Timestamp; the line is decoded through
+// Format when set. With Interval set the source keeps polling for entries newer than the
+// cursor (retrying failed queries with backoff); otherwise it closes after reading up to now.
+type LokiSource struct {
+	URL      string // Loki base URL, e.g. http://localhost:3100
+	Query    string // LogQL log query, e.g. {app="api"} |= "error"
+	SourceID string
+	Client   *http.Client
+	Since    time.Duration // start of the first query, relative to now; 0 means DefaultLokiSince
+	Limit    int           // entries per request; 0 means DefaultLokiLimit
+	Interval time.Duration // poll for new entries this often; 0 queries once
+	Format   FormatMapping
+}
+
+// ID implements Source.
+func (l *LokiSource) ID() string {
+	if l.SourceID != "" {
+		return l.SourceID
+	}
+	return "loki:" + l.Query
+}
+
+// Stream implements Source.
+func (l *LokiSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		parse, err := l.Format.parser()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		since := l.Since
+		if since <= 0 {
+			since = DefaultLokiSince
+		}
+		cur := &lokiCursor{ns: time.Now().Add(-since).UnixNano()}
+		err = poll(ctx, l.ID(), l.Interval, func(ctx context.Context) error {
+			return l.fetch(ctx, parse, cur, recCh)
+		})
+		if err != nil && ctx.Err() == nil {
+			errCh <- err
+		}
+	}()
+	return recCh, errCh
+}
+
+// lokiCursor is the read position: the newest timestamp emitted and the entries already
+// emitted at exactly that timestamp (query_range start is inclusive, so they come back).
+type lokiCursor struct {
+	ns   int64
+	seen map[string]bool
+}
+
+type lokiEntry struct {
+	ns  int64
+	key string
+	rec types.Record
+}
+
+// fetch reads every entry from the cursor up to now, one page at a time.
+func (l *LokiSource) fetch(ctx context.Context, parse lineParser, cur *lokiCursor, recCh chan<- types.Record) error {
+	limit := l.Limit
+	if limit <= 0 {
+		limit = DefaultLokiLimit
+	}
+	end := time.Now().UnixNano()
+	for cur.ns < end {
+		streams, err := l.queryRange(ctx, cur.ns, end, limit)
+		if err != nil {
+			return err
+		}
+		entries := l.entries(streams, parse)
+		emitted, err := cur.emit(ctx, entries, recCh)
+		if err != nil {
+			return err
+		}
+		if len(entries) < limit {
+			return nil
+		}
+		if emitted == 0 {
+			// A full page at one timestamp: read the rest of it, then step past it rather
+			// than asking for it again.
+			if err := l.drainTimestamp(ctx, parse, cur, limit, recCh); err != nil {
+				return err
+			}
+			cur.ns, cur.seen = cur.ns+1, nil
+		}
+	}
+	return nil
+}
+
+// maxLokiLimit caps the page size drainTimestamp asks for; it is Loki's default
+// max_entries_limit_per_query.
+const maxLokiLimit = 5000
+
+// drainTimestamp reads the entries at cur.ns that did not fit a page of limit, asking for just
+// that nanosecond with growing limits. Entries that still do not fit (or that Loki refuses to
+// return in one page) are skipped with a warning.
+func (l *LokiSource) drainTimestamp(ctx context.Context, parse lineParser, cur *lokiCursor, limit int, recCh chan<- types.Record) error {
+	for wide := limit; wide < maxLokiLimit; {
+		wide = min(wide*2, maxLokiLimit)
+		streams, err := l.queryRange(ctx, cur.ns, cur.ns+1, wide)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Fprintf(os.Stderr, "source %s: read %d entries at %d ns, skipping the rest: %v\n", l.ID(), len(cur.seen), cur.ns, err)
+			return nil
+		}
+		entries := l.entries(streams, parse)
+		if _, err := cur.emit(ctx, entries, recCh); err != nil {
+			return err
+		}
+		if len(entries) < wide {
+			return nil
+		}
+	}
+	fmt.Fprintf(os.Stderr, "source %s: more than %d entries at %d ns; entries past the first %d were skipped\n", l.ID(), maxLokiLimit, cur.ns, len(cur.seen))
+	return nil
+}
+
+// entries decodes a query_range result, oldest first.
+func (l *LokiSource) entries(streams []lokiStream, parse lineParser) []lokiEntry {
+	var entries []lokiEntry
+	for _, st := range streams {
+		streamKey := labelsKey(st.Stream)
+		for _, v := range st.Values {
+			rec, ok := lokiRecord(st.Stream, v, parse, l.ID())
+			if !ok {
+				continue
+			}
+			// The cursor follows Loki's entry time, not the time Format may have parsed
+			// from the line.
+			tsStr, _ := v[0].(string)
+			ns, err := strconv.ParseInt(tsStr, 10, 64)
+			if err != nil {
+				continue
+			}
+			line, _ := v[1].(string)
+			entries = append(entries, lokiEntry{ns: ns, key: streamKey + "\x00" + line, rec: rec})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].ns < entries[j].ns })
+	return entries
+}
+
+// emit sends the entries past the cursor and advances it. It returns how many were sent.
+func (cur *lokiCursor) emit(ctx context.Context, entries []lokiEntry, recCh chan<- types.Record) (int, error) {
+	emitted := 0
+	for _, e := range entries {
+		if e.ns < cur.ns || (e.ns == cur.ns && cur.seen[e.key]) {
+			continue
+		}
+		if e.ns > cur.ns {
+			cur.ns, cur.seen = e.ns, make(map[string]bool)
+		}
+		if cur.seen == nil {
+			cur.seen = make(map[string]bool)
+		}
+		cur.seen[e.key] = true
+		select {
+		case <-ctx.Done():
+			return emitted, ctx.Err()
+		case recCh <- e.rec:
+		}
+		emitted++
+	}
+	return emitted, nil
+}
+
+// lokiQueryResponse is the body of GET /loki/api/v1/query_range.
+type lokiQueryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+func (l *LokiSource) queryRange(ctx context.Context, start, end int64, limit int) ([]lokiStream, error) {
+	q := url.Values{}
+	q.Set("query", l.Query)
+	q.Set("start", strconv.FormatInt(start, 10))
+	q.Set("end", strconv.FormatInt(end, 10))
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("direction", "forward")
+	u := strings.TrimSuffix(l.URL, "/") + "/loki/api/v1/query_range?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := l.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("loki query_range: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var body lokiQueryResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("loki query_range: %w", err)
+	}
+	if body.Status != "success" {
+		return nil, fmt.Errorf("loki query_range: %s %s", body.Status, body.Error)
+	}
+	if body.Data.ResultType != "streams" {
+		return nil, fmt.Errorf("loki query_range: result type %q, want streams (use a log query, not a metric query)", body.Data.ResultType)
+	}
+	var streams []lokiStream
+	if err := json.Unmarshal(body.Data.Result, &streams); err != nil {
+		return nil, fmt.Errorf("loki query_range: %w", err)
+	}
+	return streams, nil
+}
+
+// labelsKey renders a label set in a stable order.
+func labelsKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -0,0 +1,231 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// fakeLoki serves query_range over an in-memory set of entries, honouring start (inclusive),
+// end (exclusive), limit and forward direction like Loki does.
+type fakeLoki struct {
+	mu       sync.Mutex
+	entries  []fakeLokiEntry
+	requests int
+}
+
+type fakeLokiEntry struct {
+	labels map[string]string
+	ns     int64
+	line   string
+}
+
+func (f *fakeLoki) add(labels map[string]string, ts time.Time, line string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = append(f.entries, fakeLokiEntry{labels, ts.UnixNano(), line})
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/loki/api/v1/query_range" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	start, _ := strconv.ParseInt(q.Get("start"), 10, 64)
+	end, _ := strconv.ParseInt(q.Get("end"), 10, 64)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	f.mu.Lock()
+	f.requests++
+	var sel []fakeLokiEntry
+	for _, e := range f.entries {
+		if e.ns >= start && e.ns < end {
+			sel = append(sel, e)
+		}
+	}
+	f.mu.Unlock()
+	sort.SliceStable(sel, func(i, j int) bool { return sel[i].ns < sel[j].ns })
+	if len(sel) > limit {
+		sel = sel[:limit]
+	}
+	byStream := map[string]*lokiStream{}
+	var result []*lokiStream
+	for _, e := range sel {
+		key := labelsKey(e.labels)
+		st, ok := byStream[key]
+		if !ok {
+			st = &lokiStream{Stream: e.labels}
+			byStream[key] = st
+			result = append(result, st)
+		}
+		st.Values = append(st.Values, []any{strconv.FormatInt(e.ns, 10), e.line})
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"status": "success",
+		"data":   map[string]any{"resultType": "streams", "result": result},
+	})
+}
+
+func TestLokiSource_Paginates(t *testing.T) {
+	fake := &fakeLoki{}
+	base := time.Now().Add(-10 * time.Minute)
+	api := map[string]string{"app": "api", "level": "error"}
+	web := map[string]string{"app": "web"}
+	fake.add(api, base, "one")
+	fake.add(web, base.Add(time.Second), "two")
+	fake.add(api, base.Add(2*time.Second), "three")
+	fake.add(web, base.Add(2*time.Second), "four") // same timestamp as "three", across a page boundary
+	fake.add(api, base.Add(3*time.Second), "five")
+	fake.add(api, base.Add(-2*time.Hour), "too old")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	src := &LokiSource{URL: srv.URL, Query: `{app=~".+"}`, Limit: 3}
+	recCh, errCh := src.Stream(ctx)
+	var recs []types.Record
+	for rec := range recCh {
+		recs = append(recs, rec)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range recs {
+		got = append(got, r.Message)
+	}
+	want := []string{"one", "two", "three", "four", "five"}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if fake.requests < 2 {
+		t.Errorf("expected several pages, got %d requests", fake.requests)
+	}
+	first := recs[0]
+	if first.Labels["app"] != "api" || first.Level != types.LevelError || !first.Timestamp.Equal(base) {
+		t.Errorf("first = %+v", first)
+	}
+	if first.SourceID != `loki:{app=~".+"}` {
+		t.Errorf("SourceID = %q", first.SourceID)
+	}
+}
+
+func TestLokiSource_Polls(t *testing.T) {
+	fake := &fakeLoki{}
+	fake.add(map[string]string{"app": "api"}, time.Now().Add(-time.Minute), "before")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &LokiSource{URL: srv.URL, Query: `{app="api"}`, SourceID: "loki", Interval: 20 * time.Millisecond,
+		Format: FormatMapping{Type: "logfmt"}}
+	recCh, _ := src.Stream(ctx)
+	if got := collect(t, recCh, 1, 2*time.Second); got[0] != "before" {
+		t.Fatalf("got %q", got)
+	}
+	fake.add(map[string]string{"app": "api"}, time.Now(), `level=warn msg="after"`)
+	rec := <-recCh
+	if rec.Message != "after" || rec.Level != types.LevelWarn {
+		t.Errorf("rec = %+v", rec)
+	}
+	select {
+	case rec := <-recCh:
+		t.Errorf("unexpected duplicate %+v", rec)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestLokiSource_MetricQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+	}))
+	defer srv.Close()
+	recCh, errCh := (&LokiSource{URL: srv.URL, Query: `rate({app="api"}[1m])`}).Stream(context.Background())
+	for range recCh {
+	}
+	if err := <-errCh; err == nil {
+		t.Error("expected an error for a metric query")
+	}
+}
+
+func TestLokiSource_FormatTimestamp(t *testing.T) {
+	fake := &fakeLoki{}
+	base := time.Now().Add(-time.Minute)
+	old := base.Add(-48 * time.Hour).UTC().Format(time.RFC3339Nano)
+	future := base.Add(time.Hour).UTC().Format(time.RFC3339Nano)
+	api := map[string]string{"app": "api"}
+	fake.add(api, base, `{"ts":"`+old+`","msg":"one"}`)
+	fake.add(api, base.Add(time.Second), `{"ts":"`+future+`","msg":"two"}`)
+	fake.add(api, base.Add(2*time.Second), `{"ts":"`+old+`","msg":"three"}`)
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &LokiSource{URL: srv.URL, Query: `{app="api"}`, Interval: 20 * time.Millisecond, Format: FormatMapping{Type: "json"}}
+	recCh, _ := src.Stream(ctx)
+	got := collect(t, recCh, 3, 2*time.Second)
+	if got[0] != "one" || got[1] != "two" || got[2] != "three" {
+		t.Fatalf("got %q", got)
+	}
+	select {
+	case rec := <-recCh:
+		t.Errorf("unexpected duplicate %+v", rec)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+// A page full of entries at one nanosecond is read on with larger pages instead of skipped;
+// only what does not fit maxLokiLimit is dropped.
+func TestLokiSource_FullPageAtOneTimestamp(t *testing.T) {
+	for _, tt := range []struct {
+		same, want int
+	}{
+		{7, 7},
+		{maxLokiLimit + 100, maxLokiLimit},
+	} {
+		fake := &fakeLoki{}
+		base := time.Now().Add(-10 * time.Minute)
+		for i := 0; i < tt.same; i++ {
+			fake.add(map[string]string{"app": "api"}, base, "burst "+strconv.Itoa(i))
+		}
+		fake.add(map[string]string{"app": "api"}, base.Add(time.Second), "after")
+		srv := httptest.NewServer(fake)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		src := &LokiSource{URL: srv.URL, Query: `{app="api"}`, Limit: 3}
+		recCh, errCh := src.Stream(ctx)
+		var got []string
+		for rec := range recCh {
+			got = append(got, rec.Message)
+		}
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		srv.Close()
+		if len(got) != tt.want+1 || got[len(got)-1] != "after" {
+			t.Errorf("%d entries at one timestamp: got %d records, last %q; want %d then after", tt.same, len(got), got[len(got)-1], tt.want)
+		}
+	}
+}
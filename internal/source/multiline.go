@@ -0,0 +1,178 @@
+package source
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+	"github.com/ailert/ailert/internal/types"
+)
+
+const (
+	// DefaultMultilineMaxLines caps how many lines are joined into one record.
+	DefaultMultilineMaxLines = 500
+	// DefaultMultilineTimeout is how long a tailing source waits for more continuation lines
+	// before emitting the pending record.
+	DefaultMultilineTimeout = time.Second
+)
+
+// defaultContinuation matches the continuation lines of common stack traces: indented frames,
+// Java "Caused by:" and "... N more", Python tracebacks and the final exception line, Go
+// goroutine headers and frames. Used when a MultilineRule sets no rule of its own.
+const defaultContinuation = `^(?:\s|Caused by:|\.\.\. \d+ more|Traceback \(most recent call last\):|[\w.$]+(?:Error|Exception)(?::|$)|goroutine \d+ \[|created by |[\w./*()-]+\(.*\)$)`
+
+// MultilineRule joins continuation lines (e.g. stack traces) into the record of the line
+// that started them, before format parsing and pattern extraction. A line continues the
+// pending record if it matches Continuation, if Indent is set and it starts with whitespace,
+// or if Start is set and it does not match Start. Blank lines never start a record.
+// With no rule set, defaultContinuation is used.
+type MultilineRule struct {
+	Start        string        `yaml:"start"`        // regex: a matching line begins a new record
+	Continuation string        `yaml:"continuation"` // regex: a matching line continues the pending record
+	Indent       bool          `yaml:"indent"`       // lines starting with space or tab continue the pending record
+	MaxLines     int           `yaml:"max_lines"`    // emit once this many lines are joined; 0 means DefaultMultilineMaxLines
+	Timeout      time.Duration `yaml:"timeout"`      // tail: emit after this long without new lines; 0 means DefaultMultilineTimeout
+}
+
+// joiner is the per-stream state of a MultilineRule: the lines of the pending record and the
+// checkpoint just past its last line.
+type joiner struct {
+	start, cont *regexp.Regexp
+	indent      bool
+	maxLines    int
+	timeout     time.Duration
+	lines       []string
+	cp          checkpoint.Checkpoint
+	last        time.Time
+}
+
+// newJoiner compiles the rule; a nil rule returns a nil joiner (no joining).
+func (r *MultilineRule) newJoiner() (*joiner, error) {
+	if r == nil {
+		return nil, nil
+	}
+	j := &joiner{indent: r.Indent, maxLines: r.MaxLines, timeout: r.Timeout}
+	if j.maxLines <= 0 {
+		j.maxLines = DefaultMultilineMaxLines
+	}
+	if j.timeout <= 0 {
+		j.timeout = DefaultMultilineTimeout
+	}
+	cont := r.Continuation
+	if r.Start == "" && cont == "" && !r.Indent {
+		cont = defaultContinuation
+	}
+	var err error
+	if r.Start != "" {
+		if j.start, err = regexp.Compile(r.Start); err != nil {
+			return nil, fmt.Errorf("multiline start: %w", err)
+		}
+	}
+	if cont != "" {
+		if j.cont, err = regexp.Compile(cont); err != nil {
+			return nil, fmt.Errorf("multiline continuation: %w", err)
+		}
+	}
+	return j, nil
+}
+
+func (j *joiner) continues(line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return true
+	}
+	if j.indent && (line[0] == ' ' || line[0] == '\t') {
+		return true
+	}
+	if j.cont != nil && j.cont.MatchString(line) {
+		return true
+	}
+	return j.start != nil && !j.start.MatchString(line)
+}
+
+// add feeds one raw line; emit receives each completed record.
+func (j *joiner) add(line string, cp checkpoint.Checkpoint, emit func(string, checkpoint.Checkpoint) bool) bool {
+	line = strings.TrimRight(line, "\r\n")
+	if len(j.lines) > 0 && !j.continues(line) {
+		if !j.flush(emit) {
+			return false
+		}
+	}
+	j.lines = append(j.lines, line)
+	j.cp = cp
+	j.last = time.Now()
+	if len(j.lines) >= j.maxLines {
+		return j.flush(emit)
+	}
+	return true
+}
+
+// flush emits the pending record, if any.
+func (j *joiner) flush(emit func(string, checkpoint.Checkpoint) bool) bool {
+	if len(j.lines) == 0 {
+		return true
+	}
+	text := strings.Join(j.lines, "\n")
+	j.lines = j.lines[:0]
+	return emit(text, j.cp)
+}
+
+// flushIdle emits the pending record once no line has been added for the timeout.
+func (j *joiner) flushIdle(emit func(string, checkpoint.Checkpoint) bool) bool {
+	if len(j.lines) == 0 || time.Since(j.last) < j.timeout {
+		return true
+	}
+	return j.flush(emit)
+}
+
+// parseJoined runs parse on the first line of a (possibly joined) record and keeps the
+// continuation lines appended to the parsed message.
+func parseJoined(parse lineParser, text string, rec *types.Record) {
+	first, rest, joined := strings.Cut(text, "\n")
+	parse(first, rec)
+	if joined {
+		rec.Message += "\n" + rest
+	}
+}
+
+// lineOut routes raw lines to a record emitter: through a container log decoder when the
+// lines are runtime-wrapped, then through a joiner when multiline is configured.
+type lineOut struct {
+	dec    *containerDecoder
+	j      *joiner
+	record func(string, checkpoint.Checkpoint) bool
+}
+
+func (o *lineOut) line(line string, cp checkpoint.Checkpoint) bool {
+	if o.dec != nil {
+		return o.dec.line(line, cp, o.join)
+	}
+	return o.join(line, cp)
+}
+
+func (o *lineOut) join(line string, cp checkpoint.Checkpoint) bool {
+	if o.j == nil {
+		return o.record(line, cp)
+	}
+	return o.j.add(line, cp, o.record)
+}
+
+// flush emits the pending partial and multiline records (end of input or of a rotated file).
+func (o *lineOut) flush() bool {
+	if o.dec != nil && !o.dec.flush(o.join) {
+		return false
+	}
+	if o.j == nil {
+		return true
+	}
+	return o.j.flush(o.record)
+}
+
+// idle emits the pending multiline record if its timeout has passed.
+func (o *lineOut) idle() bool {
+	if o.j == nil {
+		return true
+	}
+	return o.j.flushIdle(o.record)
+}
@@ -0,0 +1,164 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+	"github.com/ailert/ailert/internal/testutil"
+)
+
+func joinAll(t *testing.T, rule *MultilineRule, lines []string) []string {
+	t.Helper()
+	j, err := rule.newJoiner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	o := &lineOut{j: j, record: func(s string, _ checkpoint.Checkpoint) bool {
+		out = append(out, s)
+		return true
+	}}
+	for _, l := range lines {
+		o.line(l+"\n", checkpoint.Checkpoint{})
+	}
+	o.flush()
+	return out
+}
+
+func TestMultiline_DefaultJavaTrace(t *testing.T) {
+	got := joinAll(t, &MultilineRule{}, []string{
+		"ERROR request failed",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.Foo.bar(Foo.java:10)",
+		"\tat com.example.Main.main(Main.java:5)",
+		"Caused by: java.io.IOException: closed",
+		"\t... 2 more",
+		"INFO next request",
+	})
+	if len(got) != 2 {
+		t.Fatalf("got %d records: %q", len(got), got)
+	}
+	if !strings.HasPrefix(got[0], "ERROR request failed\njava.lang.IllegalStateException") || !strings.HasSuffix(got[0], "... 2 more") {
+		t.Errorf("record 0 = %q", got[0])
+	}
+	if got[1] != "INFO next request" {
+		t.Errorf("record 1 = %q", got[1])
+	}
+}
+
+func TestMultiline_DefaultPythonTrace(t *testing.T) {
+	got := joinAll(t, &MultilineRule{}, []string{
+		"ERROR job crashed",
+		"Traceback (most recent call last):",
+		`  File "job.py", line 3, in <module>`,
+		"ValueError: bad input",
+		"INFO done",
+	})
+	if len(got) != 2 || !strings.HasSuffix(got[0], "ValueError: bad input") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMultiline_StartPattern(t *testing.T) {
+	rule := &MultilineRule{Start: `^\d{4}-\d{2}-\d{2} `}
+	got := joinAll(t, rule, []string{
+		"2024-05-01 10:00:00 ERROR failed",
+		"details line one",
+		"details line two",
+		"2024-05-01 10:00:01 INFO ok",
+	})
+	if len(got) != 2 || got[0] != "2024-05-01 10:00:00 ERROR failed\ndetails line one\ndetails line two" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMultiline_MaxLines(t *testing.T) {
+	rule := &MultilineRule{Indent: true, MaxLines: 2}
+	got := joinAll(t, rule, []string{"head", " a", " b", " c"})
+	if len(got) != 2 || got[0] != "head\n a" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestMultiline_InvalidRegex(t *testing.T) {
+	if _, err := (&MultilineRule{Start: "("}).newJoiner(); err == nil {
+		t.Error("expected error for invalid start regex")
+	}
+}
+
+func TestFileSource_MultilineWithRegexFormat(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	lines := []string{
+		"2024-05-01 10:00:00,000 ERROR [main] com.example.App - request failed",
+		"java.lang.NullPointerException",
+		"\tat com.example.App.handle(App.java:42)",
+		"2024-05-01 10:00:01,000 INFO  [main] com.example.App - recovered",
+	}
+	if err := testutil.WriteLogLines(logPath, lines); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	src := &FileSource{
+		Path:      logPath,
+		Format:    FormatMapping{Type: "regex", Preset: "log4j"},
+		Multiline: &MultilineRule{},
+	}
+	recCh, _ := src.Stream(ctx)
+	var msgs []string
+	for rec := range recCh {
+		msgs = append(msgs, rec.Message)
+	}
+	want := "request failed\njava.lang.NullPointerException\n\tat com.example.App.handle(App.java:42)"
+	if len(msgs) != 2 || msgs[0] != want || msgs[1] != "recovered" {
+		t.Errorf("got %q", msgs)
+	}
+}
+
+func TestFileSource_MultilineTailTimeout(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "app.log")
+	if err := testutil.WriteLogLines(logPath, []string{"ERROR oops", "  at frame"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &FileSource{
+		Path:      logPath,
+		Tail:      true,
+		Poll:      10 * time.Millisecond,
+		Multiline: &MultilineRule{Indent: true, Timeout: 50 * time.Millisecond},
+	}
+	recCh, _ := src.Stream(ctx)
+	if got := collect(t, recCh, 1, 2*time.Second); got[0] != "ERROR oops\n  at frame" {
+		t.Errorf("got %q", got)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestHTTPSource_Multiline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ERROR failed\n  at a\n  at b\nINFO ok\n"))
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	src := &HTTPSource{URL: srv.URL, Multiline: &MultilineRule{Indent: true}}
+	recCh, _ := src.Stream(ctx)
+	var msgs []string
+	for rec := range recCh {
+		msgs = append(msgs, rec.Message)
+	}
+	if len(msgs) != 2 || msgs[0] != "ERROR failed\n  at a\n  at b" {
+		t.Errorf("got %q", msgs)
+	}
+}
@@ -0,0 +1,248 @@
+package source
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// OTLPPathLogs is the OTLP/HTTP logs export path served by OTLPSource.
+const OTLPPathLogs = "/v1/logs"
+
+// OTLPSource starts an OTLP/HTTP receiver for log exports (POST /v1/logs), so ailert can be
+// the target of an OpenTelemetry Collector otlphttp exporter or an SDK exporter. Bodies are
+// protobuf (application/x-protobuf) or JSON (application/json), optionally gzip-compressed.
+// Each log record becomes a Record: the body is the message (decoded through Format when
+// set), SeverityNumber sets the Level (SeverityText when unset), the record time (or
+// observed time) the Timestamp, and resource and log attributes become labels, log
+// attributes winning on conflicts.
+type OTLPSource struct {
+	Addr     string // listen address, e.g. ":4318"
+	SourceID string
+	Format   FormatMapping
+}
+
+// ID implements Source.
+func (o *OTLPSource) ID() string {
+	if o.SourceID != "" {
+		return o.SourceID
+	}
+	return "otlp:" + o.Addr
+}
+
+// Stream implements Source. Serves until ctx is done.
+func (o *OTLPSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		parse, err := o.Format.parser()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		ln, err := net.Listen("tcp", o.Addr)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		srv := &http.Server{Handler: o.handler(ctx, parse, recCh)}
+		done := make(chan error, 1)
+		go func() { done <- srv.Serve(ln) }()
+		select {
+		case <-ctx.Done():
+		case err := <-done:
+			if !errors.Is(err, http.ErrServerClosed) {
+				errCh <- err
+			}
+			return
+		}
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutCtx)
+	}()
+	return recCh, errCh
+}
+
+func (o *OTLPSource) handler(ctx context.Context, parse lineParser, recCh chan<- types.Record) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(OTLPPathLogs, func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pushBody(w, r)
+		if !ok {
+			return
+		}
+		defer body.Close()
+		data, err := io.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var req collogs.ExportLogsServiceRequest
+		switch ct {
+		case "application/x-protobuf":
+			err = proto.Unmarshal(data, &req)
+		case "application/json":
+			err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, &req)
+		default:
+			http.Error(w, "content type must be application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, rl := range req.GetResourceLogs() {
+			resource := otlpAttributes(rl.GetResource().GetAttributes())
+			for _, sl := range rl.GetScopeLogs() {
+				for _, lr := range sl.GetLogRecords() {
+					rec, ok := otlpRecord(resource, lr, parse, o.ID())
+					if !ok {
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						http.Error(w, "shutting down", http.StatusServiceUnavailable)
+						return
+					case <-r.Context().Done():
+						return
+					case recCh <- rec:
+					}
+				}
+			}
+		}
+		resp := &collogs.ExportLogsServiceResponse{}
+		var out []byte
+		if ct == "application/json" {
+			out, err = protojson.Marshal(resp)
+		} else {
+			out, err = proto.Marshal(resp)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ct)
+		w.Write(out)
+	})
+	return mux
+}
+
+// otlpRecord converts one OTLP log record into a Record; records with an empty body are skipped.
+func otlpRecord(resource map[string]string, lr *logspb.LogRecord, parse lineParser, sourceID string) (types.Record, bool) {
+	msg := strings.TrimSpace(otlpValue(lr.GetBody()))
+	if msg == "" {
+		return types.Record{}, false
+	}
+	rec := types.Record{Timestamp: time.Now(), Level: otlpSeverityLevel(lr.GetSeverityNumber()), Message: msg, SourceID: sourceID}
+	if ns := lr.GetTimeUnixNano(); ns != 0 {
+		rec.Timestamp = time.Unix(0, int64(ns))
+	} else if ns := lr.GetObservedTimeUnixNano(); ns != 0 {
+		rec.Timestamp = time.Unix(0, int64(ns))
+	}
+	if rec.Level == types.LevelUnknown {
+		rec.Level = parseLevelAlias(lr.GetSeverityText())
+	}
+	for k, v := range resource {
+		setLabel(&rec, k, v)
+	}
+	for k, v := range otlpAttributes(lr.GetAttributes()) {
+		setLabel(&rec, k, v)
+	}
+	if parse != nil {
+		parse(msg, &rec)
+	}
+	return rec, true
+}
+
+// otlpSeverityLevel maps an OTLP SeverityNumber range (TRACE 1-4, DEBUG 5-8, INFO 9-12,
+// WARN 13-16, ERROR 17-20, FATAL 21-24) to a Level.
+func otlpSeverityLevel(n logspb.SeverityNumber) types.Level {
+	switch {
+	case n <= 0:
+		return types.LevelUnknown
+	case n <= 8:
+		return types.LevelDebug
+	case n <= 12:
+		return types.LevelInfo
+	case n <= 16:
+		return types.LevelWarn
+	default:
+		return types.LevelError
+	}
+}
+
+func otlpAttributes(kvs []*commonpb.KeyValue) map[string]string {
+	if len(kvs) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		out[kv.GetKey()] = otlpValue(kv.GetValue())
+	}
+	return out
+}
+
+// otlpValue renders an AnyValue as text: scalars as themselves, bytes as base64, and arrays
+// and key-value lists as JSON.
+func otlpValue(v *commonpb.AnyValue) string {
+	switch v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.GetStringValue()
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.GetBoolValue())
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.GetIntValue(), 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.GetDoubleValue(), 'g', -1, 64)
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.GetBytesValue())
+	case *commonpb.AnyValue_ArrayValue, *commonpb.AnyValue_KvlistValue:
+		b, _ := json.Marshal(otlpJSON(v))
+		return string(b)
+	default:
+		return ""
+	}
+}
+
+func otlpJSON(v *commonpb.AnyValue) any {
+	switch v.GetValue().(type) {
+	case *commonpb.AnyValue_ArrayValue:
+		var out []any
+		for _, e := range v.GetArrayValue().GetValues() {
+			out = append(out, otlpJSON(e))
+		}
+		return out
+	case *commonpb.AnyValue_KvlistValue:
+		out := make(map[string]any)
+		for _, kv := range v.GetKvlistValue().GetValues() {
+			out[kv.GetKey()] = otlpJSON(kv.GetValue())
+		}
+		return out
+	case *commonpb.AnyValue_BoolValue:
+		return v.GetBoolValue()
+	case *commonpb.AnyValue_IntValue:
+		return v.GetIntValue()
+	case *commonpb.AnyValue_DoubleValue:
+		return v.GetDoubleValue()
+	default:
+		return otlpValue(v)
+	}
+}
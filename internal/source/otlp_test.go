@@ -0,0 +1,119 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	collogs "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func postOTLP(t *testing.T, url, contentType string, body []byte) int {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err := http.Post(url, contentType, bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, recCh <-chan types.Record, n int) []types.Record {
+	t.Helper()
+	var recs []types.Record
+	for len(recs) < n {
+		select {
+		case rec := <-recCh:
+			recs = append(recs, rec)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d of %d records", len(recs), n)
+		}
+	}
+	return recs
+}
+
+func str(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func TestOTLPSource_Protobuf(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recCh, _ := (&OTLPSource{Addr: addr, SourceID: "otel"}).Stream(ctx)
+	req := &collogs.ExportLogsServiceRequest{ResourceLogs: []*logspb.ResourceLogs{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+			{Key: "service.name", Value: str("checkout")},
+			{Key: "env", Value: str("prod")},
+		}},
+		ScopeLogs: []*logspb.ScopeLogs{{LogRecords: []*logspb.LogRecord{
+			{
+				TimeUnixNano:   uint64(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).UnixNano()),
+				SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_ERROR,
+				Body:           str("payment declined"),
+				Attributes: []*commonpb.KeyValue{
+					{Key: "env", Value: str("canary")},
+					{Key: "attempt", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: 3}}},
+				},
+			},
+			{SeverityText: "warning", Body: str("slow cart")},
+			{SeverityNumber: logspb.SeverityNumber_SEVERITY_NUMBER_INFO},
+		}}},
+	}}}
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := postOTLP(t, "http://"+addr+OTLPPathLogs, "application/x-protobuf", body); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	recs := receive(t, recCh, 2)
+	r := recs[0]
+	if r.Message != "payment declined" || r.Level != types.LevelError || r.SourceID != "otel" || !r.Timestamp.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("record 0 = %+v", r)
+	}
+	if r.Labels["service.name"] != "checkout" || r.Labels["env"] != "canary" || r.Labels["attempt"] != "3" {
+		t.Errorf("labels = %v", r.Labels)
+	}
+	if recs[1].Message != "slow cart" || recs[1].Level != types.LevelWarn {
+		t.Errorf("record 1 = %+v", recs[1])
+	}
+}
+
+func TestOTLPSource_JSON(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	recCh, _ := (&OTLPSource{Addr: addr}).Stream(ctx)
+	body := []byte(`{"resourceLogs":[{"resource":{"attributes":[{"key":"host.name","value":{"stringValue":"web-1"}}]},
+"scopeLogs":[{"scope":{"name":"app"},"logRecords":[
+{"timeUnixNano":"1714557600000000000","severityNumber":13,"body":{"kvlistValue":{"values":[{"key":"event","value":{"stringValue":"retry"}}]}},
+ "traceId":"5b8efff798038103d269b633813fc60c","attributes":[{"key":"retries","value":{"intValue":"2"}}]}]}]}]}`)
+	if code := postOTLP(t, "http://"+addr+OTLPPathLogs, "application/json", body); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	r := receive(t, recCh, 1)[0]
+	if r.Message != `{"event":"retry"}` || r.Level != types.LevelWarn || r.Labels["host.name"] != "web-1" || r.Labels["retries"] != "2" {
+		t.Errorf("record = %+v", r)
+	}
+	if !r.Timestamp.Equal(time.Unix(1714557600, 0)) {
+		t.Errorf("timestamp = %v", r.Timestamp)
+	}
+	if code := postOTLP(t, "http://"+addr+OTLPPathLogs, "text/plain", body); code != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain status = %d", code)
+	}
+}
@@ -0,0 +1,57 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"time"
+
+	"github.com/ailert/ailert/internal/metrics"
+)
+
+// maxPollBackoff caps the wait between retries of a failing polled fetch (unless the
+// interval itself is longer).
+const maxPollBackoff = 5 * time.Minute
+
+// poll runs fetch once when interval is 0, returning its error. With an interval it runs
+// fetch every interval until ctx is done: a failed fetch is counted in
+// metrics.SourceErrors, reported on stderr and retried after a jittered exponential
+// backoff, so a flaky endpoint does not end the source.
+func poll(ctx context.Context, sourceID string, interval time.Duration, fetch func(context.Context) error) error {
+	if interval <= 0 {
+		return fetch(ctx)
+	}
+	failures := 0
+	for {
+		wait := interval
+		if err := fetch(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			metrics.SourceError(sourceID)
+			failures++
+			wait = pollBackoff(interval, failures)
+			fmt.Fprintf(os.Stderr, "source %s: %v (retry in %s)\n", sourceID, err, wait.Round(time.Millisecond))
+		} else {
+			failures = 0
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pollBackoff doubles the interval for each consecutive failure, up to maxPollBackoff, and
+// picks a random wait in the upper half of that so sources failing together spread out.
+func pollBackoff(interval time.Duration, failures int) time.Duration {
+	limit := max(maxPollBackoff, interval)
+	d := interval
+	for i := 1; i < failures && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	return d/2 + rand.N(d/2+1)
+}
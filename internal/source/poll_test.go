@@ -0,0 +1,89 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/metrics"
+)
+
+func TestPollBackoff(t *testing.T) {
+	for failures := 1; failures <= 20; failures++ {
+		d := pollBackoff(time.Second, failures)
+		ceil := time.Second << (failures - 1)
+		if failures > 9 {
+			ceil = maxPollBackoff
+		}
+		if d < ceil/2 || d > ceil {
+			t.Errorf("failures=%d: backoff %s outside [%s, %s]", failures, d, ceil/2, ceil)
+		}
+	}
+	if d := pollBackoff(time.Hour, 3); d < 30*time.Minute || d > time.Hour {
+		t.Errorf("interval above the cap: backoff %s", d)
+	}
+}
+
+func TestHTTPSource_PollRetries(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if n == 1 {
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "line1\nline%d\n", n)
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &HTTPSource{URL: srv.URL, SourceID: "http-poll", Interval: 20 * time.Millisecond}
+	before := metrics.SourceErrors("http-poll")
+	recCh, errCh := src.Stream(ctx)
+	// Each response repeats line1; only the first one emits it.
+	got := collect(t, recCh, 3, 2*time.Second)
+	if got[0] != "line1" || got[1] != "line2" || got[2] != "line3" {
+		t.Errorf("got %q", got)
+	}
+	if n := metrics.SourceErrors("http-poll") - before; n != 1 {
+		t.Errorf("SourceErrors grew by %d, want 1", n)
+	}
+	cancel()
+	for range recCh {
+	}
+	if err, ok := <-errCh; ok && err != nil {
+		t.Errorf("polling source should not report an error on cancel: %v", err)
+	}
+}
+
+func TestPrometheusSource_Poll(t *testing.T) {
+	var calls atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Write([]byte("# TYPE up gauge\nup 1\nold_metric 1\n"))
+			return
+		}
+		w.Write([]byte("# TYPE up gauge\nup 1\n"))
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &PrometheusSource{URL: srv.URL, Interval: 20 * time.Millisecond}
+	recCh, _ := src.Stream(ctx)
+	got := collect(t, recCh, 3, 2*time.Second)
+	if got[0] != "new series up" || got[1] != "new series old_metric" || got[2] != "series vanished old_metric" {
+		t.Errorf("got %q", got)
+	}
+	select {
+	case rec := <-recCh:
+		t.Errorf("unchanged scrapes should emit nothing, got %+v", rec)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cancel()
+	for range recCh {
+	}
+}
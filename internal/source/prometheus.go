@@ -4,19 +4,50 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/ailert/ailert/internal/types"
 )
 
-// PrometheusSource scrapes a Prometheus /metrics endpoint and emits each non-empty,
-// non-comment line as a Record (message = line). Used for ingestion and pattern tests.
+// DefaultRateJump is the factor by which a counter's per-second rate must grow from one
+// scrape interval to the next to emit a rate jump event. A counter that was flat over the
+// previous interval never jumps.
+const DefaultRateJump = 3.0
+
+// Event kinds emitted by PrometheusSource, set as the "event" label.
+const (
+	PromEventNewSeries    = "new_series"
+	PromEventVanished     = "series_vanished"
+	PromEventCounterReset = "counter_reset"
+	PromEventRateJump     = "rate_jump"
+)
+
+// PrometheusSource scrapes a Prometheus /metrics endpoint, parses the text exposition format
+// and keeps per-series state between scrapes. Instead of one record per line it emits a
+// Record only for meaningful events: a new series appeared (every series on the first
+// scrape), a series vanished, a counter reset, or the rate of a counter that was already
+// increasing grew by more than RateJump. The message names the event and the metric (e.g.
+// "counter reset http_requests_total: 1234 -> 3"); the series labels are carried into
+// Record.Labels along with "metric" and "event". With Interval set the endpoint is scraped
+// again on that schedule until ctx is done.
 type PrometheusSource struct {
 	URL      string
 	SourceID string
 	Client   *http.Client
+	Interval time.Duration // scrape this often; 0 scrapes once
+	RateJump float64       // counter rate growth factor for a rate jump event; 0 means DefaultRateJump
+}
+
+// promSeries is the state of one series between scrapes.
+type promSeries struct {
+	sample promSample // last sample (name and labels for the vanished event)
+	value  float64
+	at     time.Time
+	rate   float64 // per-second increase over the previous interval; -1 when unknown
 }
 
 // ID implements Source.
@@ -27,54 +58,135 @@ func (p *PrometheusSource) ID() string {
 	return "prometheus:" + p.URL
 }
 
-// Stream implements Source. It performs one scrape and then closes the channel.
+// Stream implements Source. It performs one scrape and then closes the channel, unless
+// Interval is set.
 func (p *PrometheusSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
 	recCh := make(chan types.Record, 64)
 	errCh := make(chan error, 1)
 	go func() {
 		defer close(recCh)
 		defer close(errCh)
-		client := p.Client
-		if client == nil {
-			client = &http.Client{Timeout: 10 * time.Second}
-		}
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
-		if err != nil {
+		series := make(map[string]*promSeries)
+		err := poll(ctx, p.ID(), p.Interval, func(ctx context.Context) error {
+			return p.scrape(ctx, series, recCh)
+		})
+		if err != nil && ctx.Err() == nil {
 			errCh <- err
-			return
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			errCh <- err
-			return
+	}()
+	return recCh, errCh
+}
+
+func (p *PrometheusSource) scrape(ctx context.Context, series map[string]*promSeries, recCh chan<- types.Record) error {
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", p.URL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	samples, families := parseExposition(string(body))
+	for _, rec := range p.events(time.Now(), samples, families, series) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case recCh <- rec:
+		}
+	}
+	return nil
+}
+
+// events compares one scrape with the series state, updates the state and returns the
+// resulting event records.
+func (p *PrometheusSource) events(now time.Time, samples []promSample, families map[string]string, series map[string]*promSeries) []types.Record {
+	jump := p.RateJump
+	if jump <= 0 {
+		jump = DefaultRateJump
+	}
+	var out []types.Record
+	event := func(s promSample, at time.Time, kind string, level types.Level, msg string) {
+		labels := make(map[string]string, len(s.labels)+2)
+		for k, v := range s.labels {
+			labels[k] = v
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			errCh <- fmt.Errorf("GET %s: %s", p.URL, resp.Status)
-			return
+		labels["metric"] = s.name
+		labels["event"] = kind
+		out = append(out, types.Record{Timestamp: at, Level: level, Message: msg, Labels: labels, SourceID: p.ID()})
+	}
+	seen := make(map[string]bool, len(samples))
+	for _, s := range samples {
+		key := s.name + "{" + labelsKey(s.labels) + "}"
+		if seen[key] {
+			continue
 		}
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			errCh <- err
-			return
+		seen[key] = true
+		at := now
+		if !s.ts.IsZero() {
+			at = s.ts
 		}
-		for _, line := range strings.Split(string(body), "\n") {
-			line = strings.TrimSpace(line)
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			select {
-			case <-ctx.Done():
-				return
-			default:
+		prev, ok := series[key]
+		if !ok {
+			series[key] = &promSeries{sample: s, value: s.value, at: at, rate: -1}
+			event(s, at, PromEventNewSeries, types.LevelInfo, "new series "+s.name)
+			continue
+		}
+		if promCounter(s.name, families) && !math.IsNaN(s.value) && !math.IsNaN(prev.value) {
+			if s.value < prev.value {
+				event(s, at, PromEventCounterReset, types.LevelWarn, fmt.Sprintf("counter reset %s: %g -> %g", s.name, prev.value, s.value))
+				prev.rate = -1
+			} else if dt := at.Sub(prev.at).Seconds(); dt > 0 {
+				rate := (s.value - prev.value) / dt
+				// A flat counter has no rate to jump from; its first increments are not a jump.
+				if prev.rate > 0 && rate > prev.rate*jump {
+					event(s, at, PromEventRateJump, types.LevelWarn, fmt.Sprintf("rate jump %s: %.3g/s -> %.3g/s", s.name, prev.rate, rate))
+				}
+				prev.rate = rate
 			}
-			recCh <- types.Record{
-				Timestamp: time.Now(),
-				Level:     types.LevelUnknown,
-				Message:   line,
-				SourceID:  p.ID(),
+		}
+		prev.value, prev.at = s.value, at
+	}
+	var gone []string
+	for key := range series {
+		if !seen[key] {
+			gone = append(gone, key)
+		}
+	}
+	sort.Strings(gone)
+	for _, key := range gone {
+		s := series[key].sample
+		delete(series, key)
+		event(s, now, PromEventVanished, types.LevelWarn, "series vanished "+s.name)
+	}
+	return out
+}
+
+// promCounter reports whether a sample name belongs to a monotonic series: a counter, or the
+// _count/_sum/_bucket series of a histogram or summary. Untyped names ending in _total count
+// as counters too.
+func promCounter(name string, families map[string]string) bool {
+	if t, ok := families[name]; ok {
+		return t == "counter"
+	}
+	for _, suffix := range []string{"_total", "_count", "_sum", "_bucket"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			switch families[base] {
+			case "counter", "histogram", "summary":
+				return true
 			}
 		}
-	}()
-	return recCh, errCh
+	}
+	return strings.HasSuffix(name, "_total")
 }
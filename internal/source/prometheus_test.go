@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/ailert/ailert/internal/types"
 )
 
 func TestPrometheusSource_Scrape(t *testing.T) {
@@ -63,3 +65,94 @@ func TestPrometheusSource_ID(t *testing.T) {
 		t.Errorf("ID() = %q", got)
 	}
 }
+
+func TestParseExposition(t *testing.T) {
+	body := `# HELP http_requests_total Total requests
+# TYPE http_requests_total counter
+http_requests_total{method="GET",code="200"} 1027 1395066363000
+http_requests_total{method="POST",path="/a\\b \"q\"",} 3
+process_start_time_seconds 1.7e+09
+broken{code="500" 1
+rpc_duration_seconds{quantile="0.5"} NaN
+`
+	samples, families := parseExposition(body)
+	if len(samples) != 4 {
+		t.Fatalf("got %d samples: %+v", len(samples), samples)
+	}
+	if families["http_requests_total"] != "counter" {
+		t.Errorf("families = %v", families)
+	}
+	s := samples[0]
+	if s.name != "http_requests_total" || s.labels["code"] != "200" || s.value != 1027 || s.ts.UnixMilli() != 1395066363000 {
+		t.Errorf("sample 0 = %+v", s)
+	}
+	if got := samples[1].labels["path"]; got != `/a\b "q"` {
+		t.Errorf("escaped label = %q", got)
+	}
+	if samples[2].value != 1.7e9 || len(samples[2].labels) != 0 {
+		t.Errorf("sample 2 = %+v", samples[2])
+	}
+}
+
+func TestPrometheusSource_Events(t *testing.T) {
+	p := &PrometheusSource{SourceID: "prom"}
+	series := make(map[string]*promSeries)
+	t0 := time.Unix(1700000000, 0)
+	scrape := func(at time.Time, body string) []string {
+		samples, families := parseExposition(body)
+		var out []string
+		for _, rec := range p.events(at, samples, families, series) {
+			out = append(out, rec.Labels["event"]+" "+rec.Message)
+		}
+		return out
+	}
+	const typ = "# TYPE errors_total counter\n"
+	if got := scrape(t0, typ+`errors_total{code="500"} 10`+"\ntemp 20\n"); len(got) != 2 || got[0] != "new_series new series errors_total" {
+		t.Fatalf("first scrape: %q", got)
+	}
+	// Steady rate of 1/s and a changing gauge: nothing to report.
+	if got := scrape(t0.Add(10*time.Second), typ+`errors_total{code="500"} 20`+"\ntemp 25\n"); len(got) != 0 {
+		t.Errorf("steady scrape: %q", got)
+	}
+	// Rate goes from 1/s to 5/s.
+	if got := scrape(t0.Add(20*time.Second), typ+`errors_total{code="500"} 70`+"\ntemp 25\n"); len(got) != 1 || got[0] != "rate_jump rate jump errors_total: 1/s -> 5/s" {
+		t.Errorf("rate jump: %q", got)
+	}
+	if got := scrape(t0.Add(30*time.Second), typ+`errors_total{code="500"} 2`+"\ntemp 25\n"); len(got) != 1 || got[0] != "counter_reset counter reset errors_total: 70 -> 2" {
+		t.Errorf("counter reset: %q", got)
+	}
+	samples, families := parseExposition(typ + `errors_total{code="500"} 3` + "\n")
+	recs := p.events(t0.Add(40*time.Second), samples, families, series)
+	if len(recs) != 1 || recs[0].Message != "series vanished temp" || recs[0].Level != types.LevelWarn || recs[0].Labels["metric"] != "temp" {
+		t.Errorf("vanished: %+v", recs)
+	}
+	if len(series) != 1 {
+		t.Errorf("vanished series should be forgotten, state = %v", series)
+	}
+}
+
+func TestPrometheusSource_FlatCounterNoJump(t *testing.T) {
+	p := &PrometheusSource{SourceID: "prom"}
+	series := make(map[string]*promSeries)
+	t0 := time.Unix(1700000000, 0)
+	const typ = "# TYPE errors_total counter\n"
+	for i, v := range []string{"5", "5", "6", "6", "7"} {
+		samples, families := parseExposition(typ + "errors_total " + v + "\n")
+		recs := p.events(t0.Add(time.Duration(i)*10*time.Second), samples, families, series)
+		if i > 0 && len(recs) != 0 {
+			t.Errorf("scrape %d (value %s): %+v", i, v, recs)
+		}
+	}
+}
+
+func TestPromCounter(t *testing.T) {
+	families := map[string]string{"req": "counter", "lat": "histogram", "q": "summary", "g": "gauge"}
+	for name, want := range map[string]bool{
+		"req": true, "lat_bucket": true, "lat_count": true, "q_sum": true, "q": false,
+		"g": false, "untyped_total": true, "untyped": false,
+	} {
+		if got := promCounter(name, families); got != want {
+			t.Errorf("promCounter(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
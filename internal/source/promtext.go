@@ -0,0 +1,123 @@
+package source
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// promSample is one sample line of the Prometheus text exposition format.
+type promSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+	ts     time.Time // zero when the line carries no timestamp
+}
+
+// parseExposition parses the Prometheus text exposition format. It returns the samples in
+// order and the declared type of each metric family ("# TYPE <name> <type>"). Malformed
+// lines are skipped.
+func parseExposition(body string) ([]promSample, map[string]string) {
+	var samples []promSample
+	families := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			f := strings.Fields(line)
+			if len(f) >= 4 && f[1] == "TYPE" {
+				families[f[2]] = strings.ToLower(f[3])
+			}
+			continue
+		}
+		if s, ok := parseSampleLine(line); ok {
+			samples = append(samples, s)
+		}
+	}
+	return samples, families
+}
+
+// parseSampleLine parses `name{label="value",...} value [timestamp_ms]`.
+func parseSampleLine(line string) (promSample, bool) {
+	var s promSample
+	i := strings.IndexAny(line, "{ \t")
+	if i <= 0 {
+		return s, false
+	}
+	s.name = line[:i]
+	rest := line[i:]
+	if rest[0] == '{' {
+		labels, n, ok := parseLabelSet(rest)
+		if !ok {
+			return s, false
+		}
+		s.labels = labels
+		rest = rest[n:]
+	}
+	f := strings.Fields(rest)
+	if len(f) == 0 || len(f) > 2 {
+		return s, false
+	}
+	v, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return s, false
+	}
+	s.value = v
+	if len(f) == 2 {
+		ms, err := strconv.ParseInt(f[1], 10, 64)
+		if err != nil {
+			return s, false
+		}
+		s.ts = time.UnixMilli(ms)
+	}
+	return s, true
+}
+
+// parseLabelSet parses a `{k="v",...}` block at the start of s and returns the labels and
+// the number of bytes consumed. Values may contain \\, \" and \n escapes.
+func parseLabelSet(s string) (map[string]string, int, bool) {
+	labels := make(map[string]string)
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, false
+		}
+		if s[i] == '}' {
+			return labels, i + 1, true
+		}
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 {
+			return nil, 0, false
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, false
+		}
+		i++
+		var b strings.Builder
+		for i < len(s) && s[i] != '"' {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				if s[i] == 'n' {
+					b.WriteByte('\n')
+				} else {
+					b.WriteByte(s[i])
+				}
+			} else {
+				b.WriteByte(s[i])
+			}
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, false
+		}
+		i++ // closing quote
+		labels[name] = b.String()
+	}
+}
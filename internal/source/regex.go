@@ -0,0 +1,156 @@
+package source
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// regexPreset is a built-in pattern (and timestamp layout) for a common plain-text log format.
+type regexPreset struct {
+	pattern string
+	layout  string
+}
+
+// Presets use the group names understood by the regex parser: ts, level, msg, plus pri
+// (syslog priority) and status (HTTP status) from which a level is derived. Other named
+// groups become labels; variable per-request values (client address, pid, thread) are left
+// uncaptured so they do not turn into labels.
+var regexPresets = map[string]regexPreset{
+	"syslog-rfc3164": {
+		pattern: `^(?:<(?P<pri>\d{1,3})>)?(?P<ts>[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}) (?P<host>\S+) (?P<app>[^:\[\s]+)(?:\[\d+\])?: ?(?P<msg>.*)$`,
+		layout:  time.Stamp,
+	},
+	"syslog-rfc5424": {
+		pattern: `^(?:<(?P<pri>\d{1,3})>)?1 (?P<ts>\S+) (?P<host>\S+) (?P<app>\S+) \S+ \S+ (?:-|(?:\[(?:[^\]\\]|\\.)*\])+) ?(?P<msg>.*)$`,
+	},
+	"nginx-combined": {
+		pattern: `^\S+ \S+ \S+ \[(?P<ts>[^\]]+)\] "(?P<msg>(?P<method>[A-Z]+) \S+[^"]*)" (?P<status>\d{3}) (?:\d+|-) "[^"]*" "[^"]*"`,
+		layout:  clfLayout,
+	},
+	"apache-common": {
+		pattern: `^\S+ \S+ \S+ \[(?P<ts>[^\]]+)\] "(?P<msg>(?P<method>[A-Z]+) \S+[^"]*)" (?P<status>\d{3}) (?:\d+|-)`,
+		layout:  clfLayout,
+	},
+	"apache-error": {
+		pattern: `^\[(?P<ts>[A-Z][a-z]{2} [A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2}(?:\.\d+)? \d{4})\] \[(?:(?P<module>[^:\]]+):)?(?P<level>[a-z]+\d?)\](?: \[pid \d+(?::tid \d+)?\])?(?: \[client [^\]]+\])? (?P<msg>.*)$`,
+		layout:  "Mon Jan _2 15:04:05 2006",
+	},
+	"log4j": {
+		pattern: `^(?P<ts>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:[.,]\d{3})?)\s+(?P<level>TRACE|DEBUG|INFO|WARN|ERROR|FATAL)\s+(?:\[[^\]]*\]\s+)?(?P<logger>[\w.$]+)\s*[-:]?\s*(?P<msg>.*)$`,
+		layout:  "2006-01-02 15:04:05",
+	},
+}
+
+// clfLayout is the timestamp layout of the common/combined access log formats.
+const clfLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Group names with a fixed meaning in regex mode.
+const (
+	groupTimestamp = "ts"
+	groupLevel     = "level"
+	groupMessage   = "msg"
+	groupPriority  = "pri"
+	groupStatus    = "status"
+)
+
+// regexParser returns the parser for Type "regex": Pattern (or the Preset's pattern) is
+// matched against each line and its named groups fill the record. Lines that do not
+// match are left raw.
+func (m *FormatMapping) regexParser() (lineParser, error) {
+	expr, layout := m.Pattern, m.TimestampLayout
+	if m.Preset != "" {
+		p, ok := regexPresets[m.Preset]
+		if !ok {
+			return nil, fmt.Errorf("format: unknown preset %q", m.Preset)
+		}
+		if expr == "" {
+			expr = p.pattern
+		}
+		if layout == "" {
+			layout = p.layout
+		}
+	}
+	if expr == "" {
+		return nil, fmt.Errorf("format: regex needs pattern or preset")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("format: %w", err)
+	}
+	names := re.SubexpNames()
+	return func(line string, rec *types.Record) {
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			return
+		}
+		var status string
+		for i, name := range names {
+			v := match[i]
+			if name == "" || v == "" {
+				continue
+			}
+			switch name {
+			case groupMessage:
+				rec.Message = v
+			case groupLevel:
+				rec.Level = parseLevelAlias(v)
+			case groupTimestamp:
+				if ts, ok := parseTimestamp(v, layout); ok {
+					rec.Timestamp = ts
+				}
+			case groupPriority:
+				if pri, err := strconv.Atoi(v); err == nil && rec.Level == types.LevelUnknown {
+					rec.Level = syslogSeverityLevel(pri % 8)
+				}
+			default:
+				if name == groupStatus {
+					status = v
+				}
+				if v == "-" {
+					continue
+				}
+				label := name
+				if l, ok := m.LabelFields[name]; ok {
+					label = l
+				}
+				if rec.Labels == nil {
+					rec.Labels = make(map[string]string)
+				}
+				rec.Labels[label] = v
+			}
+		}
+		if rec.Level == types.LevelUnknown && status != "" {
+			rec.Level = httpStatusLevel(status)
+		}
+	}, nil
+}
+
+// syslogSeverityLevel maps a syslog severity (0 emerg .. 7 debug) to a Level.
+func syslogSeverityLevel(sev int) types.Level {
+	switch {
+	case sev <= 3:
+		return types.LevelError
+	case sev == 4:
+		return types.LevelWarn
+	case sev <= 6:
+		return types.LevelInfo
+	default:
+		return types.LevelDebug
+	}
+}
+
+// httpStatusLevel maps an HTTP status code to a Level: 5xx error, 4xx warn, else info.
+func httpStatusLevel(status string) types.Level {
+	switch status[0] {
+	case '5':
+		return types.LevelError
+	case '4':
+		return types.LevelWarn
+	default:
+		return types.LevelInfo
+	}
+}
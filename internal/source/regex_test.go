@@ -0,0 +1,124 @@
+package source
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func TestRegexPresets(t *testing.T) {
+	tests := []struct {
+		preset string
+		line   string
+		msg    string
+		level  types.Level
+		labels map[string]string
+		year   int
+	}{
+		{
+			preset: "syslog-rfc3164",
+			line:   "<11>Oct  3 14:22:01 web01 sshd[4242]: Failed password for root",
+			msg:    "Failed password for root",
+			level:  types.LevelError,
+			labels: map[string]string{"host": "web01", "app": "sshd"},
+		},
+		{
+			preset: "syslog-rfc5424",
+			line:   `<165>1 2024-05-01T10:00:00.003Z mymachine evntslog - ID47 [exampleSDID@32473 iut="3"] An application event`,
+			msg:    "An application event",
+			level:  types.LevelInfo,
+			labels: map[string]string{"host": "mymachine", "app": "evntslog"},
+			year:   2024,
+		},
+		{
+			preset: "nginx-combined",
+			line:   `10.0.0.1 - - [01/May/2024:10:00:00 +0000] "GET /api/users HTTP/1.1" 502 157 "-" "curl/8.0"`,
+			msg:    "GET /api/users HTTP/1.1",
+			level:  types.LevelError,
+			labels: map[string]string{"method": "GET", "status": "502"},
+			year:   2024,
+		},
+		{
+			preset: "apache-common",
+			line:   `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 404 2326`,
+			msg:    "GET /apache_pb.gif HTTP/1.0",
+			level:  types.LevelWarn,
+			labels: map[string]string{"method": "GET", "status": "404"},
+			year:   2000,
+		},
+		{
+			preset: "apache-error",
+			line:   "[Wed Oct 11 14:32:52.123456 2000] [core:error] [pid 35708:tid 4328636416] [client 72.15.99.187] File does not exist: /usr/local/apache2/htdocs/favicon.ico",
+			msg:    "File does not exist: /usr/local/apache2/htdocs/favicon.ico",
+			level:  types.LevelError,
+			labels: map[string]string{"module": "core"},
+			year:   2000,
+		},
+		{
+			preset: "log4j",
+			line:   "2024-05-01 10:00:00,123 WARN  [main] com.example.Service - Retrying request",
+			msg:    "Retrying request",
+			level:  types.LevelWarn,
+			labels: map[string]string{"logger": "com.example.Service"},
+			year:   2024,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.preset, func(t *testing.T) {
+			rec := parseWith(t, FormatMapping{Type: "regex", Preset: tt.preset}, tt.line)
+			if rec.Message != tt.msg {
+				t.Errorf("Message = %q, want %q", rec.Message, tt.msg)
+			}
+			if rec.Level != tt.level {
+				t.Errorf("Level = %v, want %v", rec.Level, tt.level)
+			}
+			if len(rec.Labels) != len(tt.labels) {
+				t.Errorf("Labels = %v, want %v", rec.Labels, tt.labels)
+			}
+			for k, v := range tt.labels {
+				if rec.Labels[k] != v {
+					t.Errorf("Labels[%s] = %q, want %q", k, rec.Labels[k], v)
+				}
+			}
+			if rec.Timestamp.IsZero() {
+				t.Fatal("Timestamp not parsed")
+			}
+			year := tt.year
+			if year == 0 {
+				year = time.Now().Year()
+			}
+			if y := rec.Timestamp.Year(); y != year && y != year-1 {
+				t.Errorf("Timestamp = %v, want year %d", rec.Timestamp, year)
+			}
+		})
+	}
+}
+
+func TestRegexCustomPattern(t *testing.T) {
+	m := FormatMapping{
+		Type:        "regex",
+		Pattern:     `^(?P<ts>\S+) \[(?P<level>\w+)\] (?P<component>\w+): (?P<msg>.*)$`,
+		LabelFields: map[string]string{"component": "comp"},
+	}
+	rec := parseWith(t, m, "2024-05-01T10:00:00Z [error] scheduler: job failed")
+	if rec.Message != "job failed" || rec.Level != types.LevelError || rec.Labels["comp"] != "scheduler" {
+		t.Errorf("rec = %+v", rec)
+	}
+	rec = parseWith(t, m, "no match here")
+	if rec.Message != "no match here" || rec.Labels != nil {
+		t.Errorf("non-matching line should stay raw: %+v", rec)
+	}
+}
+
+func TestRegexErrors(t *testing.T) {
+	for _, m := range []FormatMapping{
+		{Type: "regex"},
+		{Type: "regex", Preset: "nope"},
+		{Type: "regex", Pattern: "(unclosed"},
+	} {
+		if _, err := m.parser(); err == nil {
+			t.Errorf("parser(%+v) should fail", m)
+		}
+	}
+}
@@ -17,10 +17,21 @@ type Source interface {
 }
 
 // FormatMapping normalizes raw content into Record fields (e.g. timestamp layout, level field name).
-// Used by source implementations that read structured data.
+// Used by source implementations that read structured data. Type selects the line decoder:
+// "" or "raw" (the line is the message), "json", "logfmt" or "regex". Field names left empty fall
+// back to common defaults (e.g. "message"/"msg", "level"/"severity", "timestamp"/"time"/"ts").
+//
+// In regex mode, Pattern (or a built-in Preset: syslog-rfc3164, syslog-rfc5424, nginx-combined,
+// apache-common, apache-error, log4j) is matched against each line. Named groups ts, level and
+// msg fill the record; pri (syslog priority) and status (HTTP status) derive a level when there
+// is no level group; every other named group becomes a label (renamed through LabelFields).
 type FormatMapping struct {
-	TimestampLayout string            `yaml:"timestamp_layout"` // e.g. "2006-01-02T15:04:05Z07:00"
-	LevelField      string            `yaml:"level_field"`      // JSON field name for level
-	MessageField    string            `yaml:"message_field"`   // JSON field name for message
-	LabelFields     map[string]string  `yaml:"label_fields"`    // raw field name -> label name
+	Type            string            `yaml:"type"`             // "", "raw", "json", "logfmt", "regex"
+	Pattern         string            `yaml:"pattern"`          // regex: pattern with named groups
+	Preset          string            `yaml:"preset"`           // regex: built-in pattern name
+	TimestampField  string            `yaml:"timestamp_field"`  // field name for the timestamp
+	TimestampLayout string            `yaml:"timestamp_layout"` // e.g. "2006-01-02T15:04:05Z07:00"; default RFC 3339 or epoch
+	LevelField      string            `yaml:"level_field"`      // field name for level
+	MessageField    string            `yaml:"message_field"`    // field name for message
+	LabelFields     map[string]string `yaml:"label_fields"`     // raw field name -> label name
 }
@@ -0,0 +1,230 @@
+package source
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// sqlDriverAliases maps common database names to the database/sql driver names registered
+// by the ailert binary.
+var sqlDriverAliases = map[string]string{
+	"postgres":   "pgx",
+	"postgresql": "pgx",
+	"sqlite3":    "sqlite",
+}
+
+// sqlIdent is a plain (unquoted) column name, safe to splice into the wrapping query.
+var sqlIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQLSource runs a query through database/sql (Postgres, MySQL, SQLite, ClickHouse, ...) and
+// emits one Record per row. Columns are mapped by name through Format: TimestampField,
+// LevelField and MessageField (defaulting to timestamp/time/ts, level/severity/lvl and
+// message/msg), and LabelFields (column -> label). Format.Type is ignored.
+//
+// Incremental works as in DuckDBSource: the query is wrapped to return only rows whose Cursor
+// column is greater than the watermark, in cursor order, and the watermark advances as
+// records are processed and is persisted in Watermarks. Interval runs the query again on
+// that schedule for new rows; it requires Incremental, since polling a plain query would
+// emit every row again. Without it the query runs once.
+type SQLSource struct {
+	Driver      string // database/sql driver: pgx (or postgres), mysql, sqlite, clickhouse
+	DSN         string
+	DB          *sql.DB // optional; an open handle used instead of Driver and DSN
+	Query       string
+	SourceID    string
+	Format      FormatMapping
+	Incremental bool
+	Cursor      string        // incremental: cursor column; "" means DefaultCursor
+	Interval    time.Duration // incremental: poll this often; 0 runs once
+	Watermarks  Watermarks    // incremental: persists the watermark; nil keeps it in memory
+}
+
+// ID implements Source. The default names the driver and a hash of the DSN and query, so
+// unnamed sources keep apart watermarks without putting credentials in the ID.
+func (s *SQLSource) ID() string {
+	if s.SourceID != "" {
+		return s.SourceID
+	}
+	sum := md5.Sum([]byte(s.DSN + "\n" + s.Query))
+	return fmt.Sprintf("sql:%s:%x", s.driver(), sum[:6])
+}
+
+// cursor returns the watermark column, or "" when not incremental.
+func (s *SQLSource) cursor() string {
+	if !s.Incremental {
+		return ""
+	}
+	if s.Cursor == "" {
+		return DefaultCursor
+	}
+	return s.Cursor
+}
+
+func (s *SQLSource) driver() string {
+	if d, ok := sqlDriverAliases[s.Driver]; ok {
+		return d
+	}
+	return s.Driver
+}
+
+// Stream implements Source.
+func (s *SQLSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		if s.Query == "" {
+			errCh <- fmt.Errorf("sql: query is required")
+			return
+		}
+		if s.Interval > 0 && !s.Incremental {
+			errCh <- fmt.Errorf("sql: interval requires incremental, or every poll emits all rows again")
+			return
+		}
+		if s.Incremental && !sqlIdent.MatchString(s.cursor()) {
+			errCh <- fmt.Errorf("sql: cursor %q is not a plain column name", s.cursor())
+			return
+		}
+		db := s.DB
+		if db == nil {
+			var err error
+			if db, err = sql.Open(s.driver(), s.DSN); err != nil {
+				errCh <- fmt.Errorf("sql: %w", err)
+				return
+			}
+			defer db.Close()
+		}
+		wm := &watermark{}
+		if s.Incremental && s.Watermarks != nil {
+			wm.value, wm.sqlType, wm.ok = s.Watermarks.Watermark(s.ID())
+		}
+		err := poll(ctx, s.ID(), s.Interval, func(ctx context.Context) error {
+			return s.read(ctx, db, wm, recCh)
+		})
+		if err != nil && ctx.Err() == nil {
+			errCh <- err
+		}
+	}()
+	return recCh, errCh
+}
+
+// read runs the query (wrapped for the watermark in incremental mode) and emits the rows.
+func (s *SQLSource) read(ctx context.Context, db *sql.DB, wm *watermark, recCh chan<- types.Record) error {
+	q, cursor := s.Query, s.cursor()
+	var args []any
+	if cursor != "" {
+		q = "SELECT * FROM (" + strings.TrimRight(strings.TrimSpace(q), ";") + ") AS q"
+		if wm.ok {
+			arg, err := wm.arg()
+			if err != nil {
+				return err
+			}
+			placeholder := "?"
+			if s.driver() == "pgx" {
+				placeholder = "$1"
+			}
+			q += " WHERE q." + cursor + " > " + placeholder
+			args = append(args, arg)
+		}
+		q += " ORDER BY q." + cursor
+	}
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return fmt.Errorf("sql: %w", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(cols))
+	for i, c := range cols {
+		index[c] = i
+	}
+	cursorIdx := -1
+	if cursor != "" {
+		i, ok := index[cursor]
+		if !ok {
+			return fmt.Errorf("sql: cursor column %q not in query result", cursor)
+		}
+		cursorIdx = i
+	}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		rec := types.Record{Timestamp: time.Now(), Level: types.LevelUnknown, SourceID: s.ID()}
+		s.Format.apply(func(name string) (string, bool) {
+			i, ok := index[name]
+			if !ok || vals[i] == nil {
+				return "", false
+			}
+			if t, ok := vals[i].(time.Time); ok {
+				if s.Format.TimestampLayout != "" {
+					return t.Format(s.Format.TimestampLayout), true
+				}
+				return t.Format(time.RFC3339Nano), true
+			}
+			return sqlString(vals[i]), true
+		}, &rec)
+		if cursorIdx >= 0 && vals[cursorIdx] != nil {
+			value, sqlType := nativeWatermark(vals[cursorIdx])
+			*wm = watermark{value: value, sqlType: sqlType, ok: true}
+			if s.Watermarks != nil {
+				id := s.ID()
+				rec.Ack = func() { s.Watermarks.SetWatermark(id, value, sqlType) }
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case recCh <- rec:
+		}
+	}
+	return rows.Err()
+}
+
+// nativeWatermark renders a scanned cursor value as text plus a portable type name that
+// watermark.arg turns back into the same Go type for binding.
+func nativeWatermark(v any) (string, string) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), "TIMESTAMP"
+	case int64, int32, int16, int8, int, uint64, uint32, uint16, uint8, uint:
+		return fmt.Sprint(v), "BIGINT"
+	case float64, float32:
+		return fmt.Sprint(v), "DOUBLE"
+	default:
+		return sqlString(v), "VARCHAR"
+	}
+}
+
+// arg converts a watermark stored by nativeWatermark back into a query argument.
+func (w *watermark) arg() (any, error) {
+	switch w.sqlType {
+	case "TIMESTAMP":
+		return time.Parse(time.RFC3339Nano, w.value)
+	case "BIGINT":
+		return strconv.ParseInt(w.value, 10, 64)
+	case "DOUBLE":
+		return strconv.ParseFloat(w.value, 64)
+	case "VARCHAR":
+		return w.value, nil
+	default:
+		return nil, fmt.Errorf("sql: unsupported watermark type %q", w.sqlType)
+	}
+}
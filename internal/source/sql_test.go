@@ -0,0 +1,170 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+
+	_ "modernc.org/sqlite"
+)
+
+// memWatermarks is an in-memory Watermarks.
+type memWatermarks map[string][2]string
+
+func (m memWatermarks) Watermark(id string) (string, string, bool) {
+	w, ok := m[id]
+	return w[0], w[1], ok
+}
+
+func (m memWatermarks) SetWatermark(id, value, sqlType string) { m[id] = [2]string{value, sqlType} }
+
+func openAuditDB(t *testing.T) (string, *sql.DB) {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "audit.db")
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`CREATE TABLE audit (id INTEGER PRIMARY KEY, created_at TEXT, severity TEXT, action TEXT, user_name TEXT)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dsn, db
+}
+
+func insertAudit(t *testing.T, db *sql.DB, id int, sev, action, user string) {
+	t.Helper()
+	_, err := db.Exec(`INSERT INTO audit VALUES (?, ?, ?, ?, ?)`, id, fmt.Sprintf("2024-05-01T10:00:%02dZ", id), sev, action, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, src Source) []types.Record {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	recCh, errCh := src.Stream(ctx)
+	var recs []types.Record
+	for rec := range recCh {
+		if rec.Ack != nil {
+			rec.Ack()
+		}
+		recs = append(recs, rec)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return recs
+}
+
+func TestSQLSource_ColumnMapping(t *testing.T) {
+	dsn, db := openAuditDB(t)
+	insertAudit(t, db, 1, "warning", "login failed", "alice")
+	src := &SQLSource{
+		Driver:   "sqlite3",
+		DSN:      dsn,
+		Query:    `SELECT created_at, severity, action, user_name FROM audit`,
+		SourceID: "audit",
+		Format: FormatMapping{
+			TimestampField: "created_at",
+			LevelField:     "severity",
+			MessageField:   "action",
+			LabelFields:    map[string]string{"user_name": "user"},
+		},
+	}
+	recs := readAll(t, src)
+	if len(recs) != 1 {
+		t.Fatalf("got %d records", len(recs))
+	}
+	r := recs[0]
+	want := time.Date(2024, 5, 1, 10, 0, 1, 0, time.UTC)
+	if r.Message != "login failed" || r.Level != types.LevelWarn || r.Labels["user"] != "alice" || !r.Timestamp.Equal(want) || r.SourceID != "audit" {
+		t.Errorf("record = %+v", r)
+	}
+}
+
+func TestSQLSource_Incremental(t *testing.T) {
+	dsn, db := openAuditDB(t)
+	insertAudit(t, db, 1, "info", "created user", "alice")
+	insertAudit(t, db, 2, "error", "delete failed", "bob")
+	wms := memWatermarks{}
+	newSource := func() *SQLSource {
+		return &SQLSource{
+			Driver:      "sqlite",
+			DSN:         dsn,
+			Query:       `SELECT id, created_at AS ts, severity AS level, action AS message FROM audit;`,
+			SourceID:    "audit",
+			Incremental: true,
+			Cursor:      "id",
+			Watermarks:  wms,
+		}
+	}
+	if recs := readAll(t, newSource()); len(recs) != 2 || recs[0].Message != "created user" || recs[1].Level != types.LevelError {
+		t.Fatalf("first run: %+v", recs)
+	}
+	if w := wms["audit"]; w[0] != "2" || w[1] != "BIGINT" {
+		t.Errorf("watermark = %v", w)
+	}
+	insertAudit(t, db, 3, "info", "password changed", "carol")
+	if recs := readAll(t, newSource()); len(recs) != 1 || recs[0].Message != "password changed" {
+		t.Fatalf("second run: %+v", recs)
+	}
+}
+
+func TestSQLSource_Errors(t *testing.T) {
+	dsn, _ := openAuditDB(t)
+	for name, src := range map[string]*SQLSource{
+		"no query":        {Driver: "sqlite", DSN: dsn},
+		"bad cursor":      {Driver: "sqlite", DSN: dsn, Query: "SELECT 1", Incremental: true, Cursor: "id; DROP TABLE audit"},
+		"missing cursor":  {Driver: "sqlite", DSN: dsn, Query: "SELECT action FROM audit", Incremental: true, Cursor: "id"},
+		"unknown driver":  {Driver: "nosuchdb", Query: "SELECT 1"},
+		"poll full query": {Driver: "sqlite", DSN: dsn, Query: "SELECT action FROM audit", Interval: time.Second},
+	} {
+		recCh, errCh := src.Stream(context.Background())
+		for range recCh {
+		}
+		if err := <-errCh; err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSQLSource_ID(t *testing.T) {
+	a := &SQLSource{Driver: "postgres", DSN: "postgres://u:secret@db/app", Query: "SELECT * FROM audit"}
+	b := &SQLSource{Driver: "pgx", DSN: "postgres://u:secret@db/app", Query: "SELECT * FROM logins"}
+	if a.ID() == b.ID() || !strings.HasPrefix(a.ID(), "sql:pgx:") || strings.Contains(a.ID(), "secret") {
+		t.Errorf("IDs %q and %q", a.ID(), b.ID())
+	}
+	if c := (&SQLSource{Driver: "pgx", DSN: a.DSN, Query: a.Query}); c.ID() != a.ID() {
+		t.Errorf("same source, IDs %q and %q", a.ID(), c.ID())
+	}
+	if got := (&SQLSource{SourceID: "audit", Driver: "pgx"}).ID(); got != "audit" {
+		t.Errorf("ID() = %q", got)
+	}
+}
+
+func TestWatermarkArg(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	for _, v := range []any{ts, int64(42), 1.5, "abc"} {
+		value, sqlType := nativeWatermark(v)
+		got, err := (&watermark{value: value, sqlType: sqlType, ok: true}).arg()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gt, ok := got.(time.Time); ok {
+			if !gt.Equal(ts) {
+				t.Errorf("time round trip = %v", gt)
+			}
+		} else if got != v {
+			t.Errorf("round trip of %v (%T) = %v (%T)", v, v, got, got)
+		}
+	}
+}
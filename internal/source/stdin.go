@@ -0,0 +1,128 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ailert/ailert/internal/checkpoint"
+	"github.com/ailert/ailert/internal/types"
+)
+
+// StdinSource emits each non-empty line read from standard input as a Record, so ailert can
+// sit at the end of a shell pipeline (kubectl logs -f ... | ailert run -). Lines are emitted
+// as they arrive; the source ends at EOF. Format and Multiline work as in FileSource, and a
+// pending multiline record is emitted after the rule's timeout without new lines.
+type StdinSource struct {
+	SourceID  string
+	Format    FormatMapping
+	Multiline *MultilineRule
+	Reader    io.Reader // optional; read instead of os.Stdin
+}
+
+// ID implements Source.
+func (s *StdinSource) ID() string {
+	if s.SourceID != "" {
+		return s.SourceID
+	}
+	return "stdin"
+}
+
+// Stream implements Source. Reads until EOF or ctx is done.
+func (s *StdinSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		parse, err := s.Format.parser()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		j, err := s.Multiline.newJoiner()
+		if err != nil {
+			errCh <- err
+			return
+		}
+		record := func(line string, _ checkpoint.Checkpoint) bool {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				return true
+			}
+			rec := types.Record{Timestamp: time.Now(), Level: types.LevelUnknown, Message: line, SourceID: s.ID()}
+			if parse != nil {
+				parseJoined(parse, line, &rec)
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case recCh <- rec:
+				return true
+			}
+		}
+		if err := s.read(ctx, &lineOut{j: j, record: record}); err != nil && ctx.Err() == nil {
+			errCh <- err
+		}
+	}()
+	return recCh, errCh
+}
+
+// read hands lines to out as they arrive. The blocking reads run in their own goroutine so
+// pending multiline records are still emitted on time while the pipe is quiet.
+func (s *StdinSource) read(ctx context.Context, out *lineOut) error {
+	r := s.Reader
+	if r == nil {
+		r = os.Stdin
+	}
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				select {
+				case <-ctx.Done():
+					return
+				case lines <- line:
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					readErr <- err
+				}
+				return
+			}
+		}
+	}()
+	tick := time.NewTicker(DefaultTailPoll)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			if !out.idle() {
+				return nil
+			}
+		case line, ok := <-lines:
+			if !ok {
+				out.flush()
+				select {
+				case err := <-readErr:
+					return err
+				default:
+					return nil
+				}
+			}
+			if !out.line(line, checkpoint.Checkpoint{}) {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package source
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func TestStdinSource_Lines(t *testing.T) {
+	in := strings.NewReader("ERROR first\n\n{\"level\":\"warn\",\"msg\":\"second\"}\nno newline at end")
+	recs := readAll(t, &StdinSource{Reader: in, Format: FormatMapping{Type: "json"}})
+	if len(recs) != 3 {
+		t.Fatalf("got %d records: %+v", len(recs), recs)
+	}
+	if recs[0].Message != "ERROR first" || recs[0].SourceID != "stdin" {
+		t.Errorf("record 0 = %+v", recs[0])
+	}
+	if recs[1].Message != "second" || recs[1].Level != types.LevelWarn {
+		t.Errorf("record 1 = %+v", recs[1])
+	}
+	if recs[2].Message != "no newline at end" {
+		t.Errorf("record 2 = %+v", recs[2])
+	}
+}
+
+func TestStdinSource_MultilineTimeout(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	src := &StdinSource{Reader: pr, Multiline: &MultilineRule{Timeout: 100 * time.Millisecond}}
+	recCh, _ := src.Stream(ctx)
+	if _, err := io.WriteString(pw, "ERROR boom\njava.lang.RuntimeException: x\n\tat Main.main(Main.java:1)\n"); err != nil {
+		t.Fatal(err)
+	}
+	// The pipe stays open: the joined record must come out on the idle timeout, not at EOF.
+	select {
+	case rec := <-recCh:
+		if strings.Count(rec.Message, "\n") != 2 {
+			t.Errorf("message = %q", rec.Message)
+		}
+	case <-ctx.Done():
+		t.Fatal("no record before the pipe was closed")
+	}
+}
@@ -0,0 +1,290 @@
+package source
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+// maxSyslogMessage bounds one syslog message (UDP datagram or TCP frame).
+const maxSyslogMessage = 64 * 1024
+
+// SyslogSource listens for syslog messages over UDP and/or TCP and emits one Record per
+// message. Both RFC 3164 (BSD) and RFC 5424 headers are parsed: the timestamp becomes
+// Record.Timestamp, the severity becomes the Level, and host, app and facility become labels.
+// TCP accepts octet-counted framing ("<len> <msg>", RFC 6587) and newline-delimited messages.
+type SyslogSource struct {
+	Addr     string // listen address, e.g. ":5514"
+	Protocol string // "udp", "tcp", or "" for both
+	SourceID string
+}
+
+// ID implements Source.
+func (s *SyslogSource) ID() string {
+	if s.SourceID != "" {
+		return s.SourceID
+	}
+	return "syslog:" + s.Addr
+}
+
+// Stream implements Source. Listens until ctx is done.
+func (s *SyslogSource) Stream(ctx context.Context) (<-chan types.Record, <-chan error) {
+	recCh := make(chan types.Record, 64)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(recCh)
+		defer close(errCh)
+		if s.Protocol != "" && s.Protocol != "udp" && s.Protocol != "tcp" {
+			errCh <- fmt.Errorf("syslog: unknown protocol %q", s.Protocol)
+			return
+		}
+		var closers []io.Closer
+		var connMu sync.Mutex
+		conns := make(map[net.Conn]bool) // open TCP connections; nil once shut down
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		defer func() {
+			// Listeners first so nothing new is accepted, then the open connections.
+			for _, c := range closers {
+				c.Close()
+			}
+			connMu.Lock()
+			for c := range conns {
+				c.Close()
+			}
+			conns = nil
+			connMu.Unlock()
+		}()
+		emit := func(msg string) bool {
+			msg = strings.TrimRight(msg, "\r\n\x00")
+			if strings.TrimSpace(msg) == "" {
+				return true
+			}
+			rec := types.Record{Timestamp: time.Now(), Level: types.LevelUnknown, SourceID: s.ID()}
+			parseSyslog(msg, &rec)
+			select {
+			case <-ctx.Done():
+				return false
+			case recCh <- rec:
+				return true
+			}
+		}
+		if s.Protocol == "" || s.Protocol == "udp" {
+			pc, err := net.ListenPacket("udp", s.Addr)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			closers = append(closers, pc)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				buf := make([]byte, maxSyslogMessage)
+				for {
+					n, _, err := pc.ReadFrom(buf)
+					if err != nil {
+						return
+					}
+					if !emit(string(buf[:n])) {
+						return
+					}
+				}
+			}()
+		}
+		if s.Protocol == "" || s.Protocol == "tcp" {
+			ln, err := net.Listen("tcp", s.Addr)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			closers = append(closers, ln)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					connMu.Lock()
+					if conns == nil {
+						// Accepted while shutting down.
+						connMu.Unlock()
+						conn.Close()
+						return
+					}
+					conns[conn] = true
+					connMu.Unlock()
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						readSyslogStream(bufio.NewReader(conn), emit)
+						connMu.Lock()
+						delete(conns, conn)
+						connMu.Unlock()
+						conn.Close()
+					}()
+				}
+			}()
+		}
+		<-ctx.Done()
+	}()
+	return recCh, errCh
+}
+
+// readSyslogStream reads framed messages from a TCP stream. Each frame is octet-counted when
+// it starts with a digit, else it runs to the next newline.
+func readSyslogStream(r *bufio.Reader, emit func(string) bool) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		if b[0] >= '0' && b[0] <= '9' {
+			lenStr, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(lenStr, " "))
+			if err != nil || n <= 0 || n > maxSyslogMessage {
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			if !emit(string(buf)) {
+				return
+			}
+			continue
+		}
+		line, err := r.ReadString('\n')
+		if line != "" && !emit(line) {
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+var syslogFacilities = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// parseSyslog fills rec from an RFC 5424 or RFC 3164 message. Unparseable parts are left as
+// they are: in the worst case the whole text becomes the message.
+func parseSyslog(msg string, rec *types.Record) {
+	rec.Message = msg
+	rest := msg
+	if strings.HasPrefix(rest, "<") {
+		if end := strings.IndexByte(rest, '>'); end > 1 && end <= 4 {
+			if pri, err := strconv.Atoi(rest[1:end]); err == nil && pri >= 0 && pri < 192 {
+				rec.Level = syslogSeverityLevel(pri % 8)
+				setLabel(rec, "facility", syslogFacilities[pri/8])
+				rest = rest[end+1:]
+			}
+		}
+	}
+	if strings.HasPrefix(rest, "1 ") {
+		parseRFC5424(rest[2:], rec)
+		return
+	}
+	parseRFC3164(rest, rec)
+}
+
+// parseRFC5424 parses "TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD [MSG]".
+func parseRFC5424(rest string, rec *types.Record) {
+	fields := make([]string, 0, 5)
+	for len(fields) < 5 {
+		i := strings.IndexByte(rest, ' ')
+		if i < 0 {
+			fields = append(fields, rest)
+			rest = ""
+			break
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i+1:]
+	}
+	if len(fields) < 5 {
+		rec.Message = strings.TrimSpace(rest)
+		return
+	}
+	if ts, ok := parseTimestamp(fields[0], ""); ok {
+		rec.Timestamp = ts
+	}
+	setLabel(rec, "host", fields[1])
+	setLabel(rec, "app", fields[2])
+	// Structured data: "-" or one or more [id k="v" ...] elements; ']' may be escaped.
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		for strings.HasPrefix(rest, "[") {
+			i := 1
+			for i < len(rest) && rest[i] != ']' {
+				if rest[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(rest) {
+				rest = ""
+				break
+			}
+			rest = rest[i+1:]
+		}
+	}
+	rest = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff") // optional UTF-8 BOM
+	rec.Message = rest
+}
+
+// parseRFC3164 parses "Mmm dd hh:mm:ss HOSTNAME TAG[pid]: MSG".
+func parseRFC3164(rest string, rec *types.Record) {
+	rec.Message = rest
+	if len(rest) < len(time.Stamp)+1 {
+		return
+	}
+	ts, ok := parseTimestamp(rest[:len(time.Stamp)], time.Stamp)
+	if !ok {
+		return
+	}
+	rec.Timestamp = ts
+	rest = strings.TrimPrefix(rest[len(time.Stamp):], " ")
+	rec.Message = rest
+	host, after, ok := strings.Cut(rest, " ")
+	if !ok {
+		return
+	}
+	setLabel(rec, "host", host)
+	rec.Message = after
+	tag, msg, ok := strings.Cut(after, ":")
+	if !ok || strings.ContainsAny(tag, " \t") {
+		return
+	}
+	if i := strings.IndexByte(tag, '['); i > 0 {
+		tag = tag[:i]
+	}
+	setLabel(rec, "app", tag)
+	rec.Message = strings.TrimPrefix(msg, " ")
+}
+
+// setLabel sets a label unless the value is empty or the syslog nil value "-".
+func setLabel(rec *types.Record, name, value string) {
+	if value == "" || value == "-" {
+		return
+	}
+	if rec.Labels == nil {
+		rec.Labels = make(map[string]string)
+	}
+	rec.Labels[name] = value
+}
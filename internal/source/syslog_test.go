@@ -0,0 +1,177 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ailert/ailert/internal/types"
+)
+
+func TestParseSyslog_RFC5424(t *testing.T) {
+	var rec types.Record
+	parseSyslog(`<165>1 2024-05-01T10:00:00.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application\]"] An application event`, &rec)
+	if rec.Message != "An application event" {
+		t.Errorf("Message = %q", rec.Message)
+	}
+	if rec.Level != types.LevelInfo { // 165 % 8 = 5 (notice)
+		t.Errorf("Level = %v", rec.Level)
+	}
+	if rec.Labels["facility"] != "local4" || rec.Labels["host"] != "mymachine.example.com" || rec.Labels["app"] != "evntslog" {
+		t.Errorf("Labels = %v", rec.Labels)
+	}
+	if rec.Timestamp.Year() != 2024 {
+		t.Errorf("Timestamp = %v", rec.Timestamp)
+	}
+}
+
+func TestParseSyslog_RFC3164(t *testing.T) {
+	var rec types.Record
+	parseSyslog("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed for lonvick on /dev/pts/8", &rec)
+	if rec.Message != "'su root' failed for lonvick on /dev/pts/8" {
+		t.Errorf("Message = %q", rec.Message)
+	}
+	if rec.Level != types.LevelError { // 34 % 8 = 2 (crit)
+		t.Errorf("Level = %v", rec.Level)
+	}
+	if rec.Labels["facility"] != "auth" || rec.Labels["host"] != "mymachine" || rec.Labels["app"] != "su" {
+		t.Errorf("Labels = %v", rec.Labels)
+	}
+	if rec.Timestamp.Month() != time.October || rec.Timestamp.Day() != 11 {
+		t.Errorf("Timestamp = %v", rec.Timestamp)
+	}
+}
+
+func TestParseSyslog_Unparseable(t *testing.T) {
+	var rec types.Record
+	parseSyslog("just some text", &rec)
+	if rec.Message != "just some text" || rec.Labels != nil {
+		t.Errorf("rec = %+v", rec)
+	}
+}
+
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// dialRetry dials until the listener is up.
+func dialRetry(t *testing.T, network, addr string) net.Conn {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			return conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSyslogSource_TCPFraming(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &SyslogSource{Addr: addr, Protocol: "tcp", SourceID: "syslog"}
+	recCh, _ := src.Stream(ctx)
+	conn := dialRetry(t, "tcp", addr)
+	msg := "<11>1 2024-05-01T10:00:00Z host app - - - octet counted"
+	fmt.Fprintf(conn, "%d %s", len(msg), msg)
+	fmt.Fprint(conn, "<12>May  1 10:00:00 host app: newline framed\n")
+	conn.Close()
+	got := collect(t, recCh, 2, 2*time.Second)
+	if got[0] != "octet counted" || got[1] != "newline framed" {
+		t.Errorf("got %q", got)
+	}
+	cancel()
+	for range recCh {
+	}
+}
+
+func TestSyslogSource_ShutdownWithOpenConns(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &SyslogSource{Addr: addr, Protocol: "tcp"}
+	recCh, _ := src.Stream(ctx)
+	idle := dialRetry(t, "tcp", addr)
+	defer idle.Close()
+	// Keep connecting while shutting down, so some are accepted as the listener closes.
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 200 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if conn, err := net.Dial("tcp", addr); err == nil {
+				defer conn.Close()
+			}
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case _, ok := <-recCh:
+		if ok {
+			t.Error("unexpected record")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stream did not close with connections open")
+	}
+	close(stop)
+	<-done
+}
+
+func TestSyslogSource_UDP(t *testing.T) {
+	addr := freePort(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	src := &SyslogSource{Addr: addr, Protocol: "udp"}
+	recCh, errCh := src.Stream(ctx)
+	conn := dialRetry(t, "udp", addr)
+	defer conn.Close()
+	// UDP gives no delivery signal: resend until the listener picks one up.
+	deadline := time.After(2 * time.Second)
+	for {
+		fmt.Fprint(conn, "<11>May  1 10:00:00 web01 nginx: upstream timed out")
+		select {
+		case rec := <-recCh:
+			if rec.Message != "upstream timed out" || rec.Level != types.LevelError || rec.Labels["host"] != "web01" {
+				t.Errorf("rec = %+v", rec)
+			}
+			cancel()
+			for range recCh {
+			}
+			return
+		case err := <-errCh:
+			t.Fatal(err)
+		case <-deadline:
+			t.Fatal("no syslog message received over UDP")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestSyslogSource_BadProtocol(t *testing.T) {
+	src := &SyslogSource{Addr: "127.0.0.1:0", Protocol: "sctp"}
+	recCh, errCh := src.Stream(context.Background())
+	for range recCh {
+	}
+	if err := <-errCh; err == nil {
+		t.Error("expected error for unknown protocol")
+	}
+}
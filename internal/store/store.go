@@ -6,17 +6,23 @@ import (
 	"path/filepath"
 	"sync"
 
+	"github.com/ailert/ailert/internal/pattern"
 	"github.com/ailert/ailert/internal/types"
 )
 
 // PatternStore is the interface used by the engine for pattern/suppression state.
 // Implementations: in-memory Store (with optional JSON persist) or DuckDB-backed store.
 type PatternStore interface {
-	Seen(level types.Level, hash string, sample string) (isNew bool)
+	Seen(level types.Level, hash string, sample string, template string, words []string) (isNew bool)
 	GetCount(level types.Level, hash string) int64
 	Suppress(hash string, reason string)
 	IsSuppressed(hash string) bool
+	Merge(level types.Level, from, into string)
+	Resolve(hash string) string
+	SetScheme(scheme Scheme)
+	Rehash(renames []Rename, scheme Scheme) error
 	ListSeen() []PatternInfo
+	ListSuppressed() map[string]string // hash -> reason
 	Load() error
 	Save() error
 }
@@ -27,9 +33,19 @@ type Store struct {
 	mu          sync.RWMutex
 	seen        map[patternKey]patternStat
 	suppressed  map[string]string // hash -> reason
+	merged      map[string]string // merged hash -> hash it was merged into
+	scheme      Scheme
 	persistPath string
 }
 
+// Scheme is how pattern hashes are computed: the hashing scheme (pattern.HashVersion) and
+// the fingerprint of the mask rules (pattern.MaskFingerprint). Patterns are stored with the
+// scheme in effect when they were first seen.
+type Scheme struct {
+	Version int
+	Masks   string
+}
+
 type patternKey struct {
 	Level types.Level
 	Hash  string
@@ -37,7 +53,11 @@ type patternKey struct {
 
 type patternStat struct {
 	Sample   string
+	Template string
+	Words    []string
 	Count    int64
+	Version  int
+	Masks    string
 }
 
 // New returns an in-memory store. If persistPath is non-empty, Load/Save will use it.
@@ -45,26 +65,43 @@ func New(persistPath string) *Store {
 	s := &Store{
 		seen:       make(map[patternKey]patternStat),
 		suppressed: make(map[string]string),
+		merged:     make(map[string]string),
+		scheme:      Scheme{Version: pattern.HashVersion},
 		persistPath: persistPath,
 	}
 	return s
 }
 
+// SetScheme sets the scheme patterns seen from now on are stored with. The default is
+// pattern.HashVersion without mask rules.
+func (s *Store) SetScheme(scheme Scheme) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheme = scheme
+}
+
 // Seen returns whether this (level, hash) was seen before and updates the count.
-// Returns true if this is the first time (new pattern).
-func (s *Store) Seen(level types.Level, hash string, sample string) (isNew bool) {
+// Returns true if this is the first time (new pattern). A non-empty template or non-nil
+// words replace the stored ones, as patterns only become more general.
+func (s *Store) Seen(level types.Level, hash string, sample string, template string, words []string) (isNew bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	key := patternKey{Level: level, Hash: hash}
 	stat, ok := s.seen[key]
 	if !ok {
-		s.seen[key] = patternStat{Sample: sample, Count: 1}
+		s.seen[key] = patternStat{Sample: sample, Template: template, Words: words, Count: 1, Version: s.scheme.Version, Masks: s.scheme.Masks}
 		return true
 	}
 	stat.Count++
 	if stat.Sample == "" && sample != "" {
 		stat.Sample = sample
 	}
+	if template != "" {
+		stat.Template = template
+	}
+	if words != nil {
+		stat.Words = words
+	}
 	s.seen[key] = stat
 	return false
 }
@@ -91,6 +128,117 @@ func (s *Store) IsSuppressed(hash string) bool {
 	return ok
 }
 
+// Merge folds pattern from into pattern into after the engine merged them: the count is
+// added to into, a suppression of from is copied to into, and from -> into is kept so
+// Resolve can follow it. If into has no entry yet (it was suppressed before it was seen),
+// it gets one with from's count only; from's sample describes another pattern.
+func (s *Store) Merge(level types.Level, from, into string) {
+	if from == into {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fk, ik := patternKey{Level: level, Hash: from}, patternKey{Level: level, Hash: into}
+	if f, ok := s.seen[fk]; ok {
+		delete(s.seen, fk)
+		if i, ok := s.seen[ik]; ok {
+			i.Count += f.Count
+			s.seen[ik] = i
+		} else {
+			s.seen[ik] = patternStat{Count: f.Count, Version: f.Version, Masks: f.Masks}
+		}
+	}
+	if reason, ok := s.suppressed[from]; ok {
+		if _, ok := s.suppressed[into]; !ok {
+			s.suppressed[into] = reason
+		}
+	}
+	s.merged[from] = into
+}
+
+// Resolve returns the hash a pattern was merged into, following merges, or hash itself.
+func (s *Store) Resolve(hash string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for range len(s.merged) {
+		into, ok := s.merged[hash]
+		if !ok {
+			break
+		}
+		hash = into
+	}
+	return hash
+}
+
+// Rename moves a pattern to the hash a new hashing scheme gives it.
+type Rename struct {
+	Level    types.Level
+	From, To string
+}
+
+// Rehash applies renames after a hash scheme change and marks every pattern with scheme,
+// which also becomes the scheme of patterns seen from now on. Patterns renamed to the same
+// hash are combined, suppressions move to the new hashes, and each rename is kept for
+// Resolve. All renames are applied at once, so a new hash may equal another pattern's old
+// one; that hash then resolves to itself.
+func (s *Store) Rehash(renames []Rename, scheme Scheme) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	moved := make(map[patternKey]patternStat, len(renames))
+	for _, r := range renames {
+		k := patternKey{Level: r.Level, Hash: r.From}
+		if stat, ok := s.seen[k]; ok && r.From != r.To {
+			stat.Words = nil // matched on with the old scheme; rebuilt from the sample
+			moved[patternKey{Level: r.Level, Hash: r.To}] = addStat(moved[patternKey{Level: r.Level, Hash: r.To}], stat)
+			delete(s.seen, k)
+		}
+	}
+	for k, stat := range moved {
+		s.seen[k] = addStat(s.seen[k], stat)
+	}
+	for k, stat := range s.seen {
+		stat.Version, stat.Masks = scheme.Version, scheme.Masks
+		s.seen[k] = stat
+	}
+	s.scheme = scheme
+	suppressed := make(map[string]string)
+	for _, r := range renames {
+		if reason, ok := s.suppressed[r.From]; ok && r.From != r.To {
+			suppressed[r.To] = reason
+		}
+	}
+	targets := make(map[string]bool, len(renames))
+	for _, r := range renames {
+		targets[r.To] = true
+	}
+	for _, r := range renames {
+		if r.From != r.To {
+			delete(s.suppressed, r.From)
+			if !targets[r.From] { // reused by another pattern, which must not resolve away
+				s.merged[r.From] = r.To
+			}
+		}
+	}
+	for h := range targets {
+		delete(s.merged, h)
+	}
+	for h, reason := range suppressed {
+		if _, ok := s.suppressed[h]; !ok {
+			s.suppressed[h] = reason
+		}
+	}
+	return nil
+}
+
+// addStat combines two entries of one pattern; a zero a takes b as is.
+func addStat(a, b patternStat) patternStat {
+	if a.Count == 0 && a.Sample == "" {
+		return b
+	}
+	a.Count += b.Count
+	return a
+}
+
 // ListSeen returns a snapshot of seen patterns (for CLI/summary).
 func (s *Store) ListSeen() []PatternInfo {
 	s.mu.RLock()
@@ -100,32 +248,56 @@ func (s *Store) ListSeen() []PatternInfo {
 		out = append(out, PatternInfo{
 			Level:  k.Level,
 			Hash:   k.Hash,
-			Sample: v.Sample,
-			Count:  v.Count,
+			Sample:   v.Sample,
+			Template: v.Template,
+			Words:    v.Words,
+			Count:    v.Count,
+			Version:  v.Version,
+			Masks:    v.Masks,
 		})
 	}
 	return out
 }
 
+// ListSuppressed returns the suppressed hashes with their reasons.
+func (s *Store) ListSuppressed() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]string, len(s.suppressed))
+	for h, reason := range s.suppressed {
+		out[h] = reason
+	}
+	return out
+}
+
 // PatternInfo is a read-only view of a stored pattern.
 type PatternInfo struct {
-	Level  types.Level
-	Hash   string
-	Sample string
-	Count  int64
+	Level    types.Level
+	Hash     string
+	Sample   string
+	Template string   // with placeholders; empty for patterns stored before templates were kept
+	Words    []string // words the engine matches lines on (pattern.Result.Words); nil if not kept
+	Count    int64
+	Version  int    // hashing scheme of Hash (pattern.HashVersion); 0 for patterns stored before versions were kept, which used scheme 1
+	Masks    string // fingerprint of the mask rules Hash was computed with (pattern.MaskFingerprint)
 }
 
 // persistState is the on-disk shape (optional JSON).
 type persistState struct {
 	Seen       []patternStatPersist `json:"seen"`
 	Suppressed map[string]string    `json:"suppressed"`
+	Merged     map[string]string    `json:"merged,omitempty"`
 }
 
 type patternStatPersist struct {
-	Level  types.Level `json:"level"`
-	Hash   string      `json:"hash"`
-	Sample string      `json:"sample"`
-	Count  int64       `json:"count"`
+	Level    types.Level `json:"level"`
+	Hash     string      `json:"hash"`
+	Sample   string      `json:"sample"`
+	Template string      `json:"template,omitempty"`
+	Words    []string    `json:"words,omitempty"`
+	Count    int64       `json:"count"`
+	Version  int         `json:"hash_version,omitempty"`
+	Masks    string      `json:"masks,omitempty"`
 }
 
 // Load restores state from persistPath if set and file exists.
@@ -147,11 +319,14 @@ func (s *Store) Load() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for _, v := range state.Seen {
-		s.seen[patternKey{Level: v.Level, Hash: v.Hash}] = patternStat{Sample: v.Sample, Count: v.Count}
+		s.seen[patternKey{Level: v.Level, Hash: v.Hash}] = patternStat{Sample: v.Sample, Template: v.Template, Words: v.Words, Count: v.Count, Version: v.Version, Masks: v.Masks}
 	}
 	for hash, reason := range state.Suppressed {
 		s.suppressed[hash] = reason
 	}
+	for from, into := range state.Merged {
+		s.merged[from] = into
+	}
 	return nil
 }
 
@@ -166,11 +341,17 @@ func (s *Store) Save() error {
 		Suppressed: make(map[string]string),
 	}
 	for k, v := range s.seen {
-		state.Seen = append(state.Seen, patternStatPersist{Level: k.Level, Hash: k.Hash, Sample: v.Sample, Count: v.Count})
+		state.Seen = append(state.Seen, patternStatPersist{Level: k.Level, Hash: k.Hash, Sample: v.Sample, Template: v.Template, Words: v.Words, Count: v.Count, Version: v.Version, Masks: v.Masks})
 	}
 	for k, v := range s.suppressed {
 		state.Suppressed[k] = v
 	}
+	if len(s.merged) > 0 {
+		state.Merged = make(map[string]string, len(s.merged))
+		for k, v := range s.merged {
+			state.Merged[k] = v
+		}
+	}
 	s.mu.RUnlock()
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
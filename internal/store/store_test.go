@@ -3,18 +3,20 @@ package store
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/ailert/ailert/internal/pattern"
 	"github.com/ailert/ailert/internal/types"
 )
 
 func TestStoreSeen(t *testing.T) {
 	st := New("")
-	isNew := st.Seen(types.LevelError, "abc123", "sample line")
+	isNew := st.Seen(types.LevelError, "abc123", "sample line", "", nil)
 	if !isNew {
 		t.Error("first Seen should be new")
 	}
-	isNew = st.Seen(types.LevelError, "abc123", "another")
+	isNew = st.Seen(types.LevelError, "abc123", "another", "", nil)
 	if isNew {
 		t.Error("second Seen should not be new")
 	}
@@ -25,7 +27,7 @@ func TestStoreSeen(t *testing.T) {
 
 func TestStoreSuppress(t *testing.T) {
 	st := New("")
-	st.Seen(types.LevelWarn, "xyz", "warn sample")
+	st.Seen(types.LevelWarn, "xyz", "warn sample", "", nil)
 	st.Suppress("xyz", "noise")
 	if !st.IsSuppressed("xyz") {
 		t.Error("IsSuppressed should be true")
@@ -39,7 +41,7 @@ func TestStorePersist(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "store.json")
 	st := New(path)
-	st.Seen(types.LevelError, "h1", "sample1")
+	st.Seen(types.LevelError, "h1", "sample1", "sample<NUM>", nil)
 	st.Suppress("h1", "test")
 	if err := st.Save(); err != nil {
 		t.Fatal(err)
@@ -54,14 +56,17 @@ func TestStorePersist(t *testing.T) {
 	if !st2.IsSuppressed("h1") {
 		t.Error("after Load IsSuppressed should be true")
 	}
+	if l := st2.ListSeen(); len(l) != 1 || l[0].Template != "sample<NUM>" || l[0].Version != pattern.HashVersion {
+		t.Errorf("after Load ListSeen = %+v", l)
+	}
 	os.Remove(path)
 }
 
 func TestStoreListSeen_MultipleLevels(t *testing.T) {
 	st := New("")
-	st.Seen(types.LevelError, "h1", "e1")
-	st.Seen(types.LevelWarn, "h2", "w1")
-	st.Seen(types.LevelInfo, "h3", "i1")
+	st.Seen(types.LevelError, "h1", "e1", "", nil)
+	st.Seen(types.LevelWarn, "h2", "w1", "", nil)
+	st.Seen(types.LevelInfo, "h3", "i1", "", nil)
 	list := st.ListSeen()
 	if len(list) != 3 {
 		t.Fatalf("ListSeen len = %d, want 3", len(list))
@@ -74,3 +79,101 @@ func TestStoreListSeen_MultipleLevels(t *testing.T) {
 		t.Errorf("ListSeen by level: %v", byLevel)
 	}
 }
+
+func TestStoreMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	st := New(path)
+	st.Seen(types.LevelError, "a", "user alice logged in", "user <*> logged in", []string{"user", "<*>", "logged", "in"})
+	st.Seen(types.LevelError, "b", "user mary ann logged in", "user <*> <*> logged in", nil)
+	st.Seen(types.LevelError, "b", "user jo bee logged in", "", nil)
+	st.Seen(types.LevelWarn, "c", "moved", "", nil)
+	st.Suppress("b", "noisy")
+	st.Merge(types.LevelError, "b", "a")
+	// A pattern merged before its target was stored only hands over its count.
+	st.Merge(types.LevelWarn, "c", "d")
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+	st = New(path)
+	if err := st.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if c := st.GetCount(types.LevelError, "a"); c != 3 {
+		t.Errorf("count of a = %d, want 3", c)
+	}
+	if c := st.GetCount(types.LevelError, "b"); c != 0 {
+		t.Errorf("count of b = %d, want 0", c)
+	}
+	if st.GetCount(types.LevelWarn, "d") != 1 || len(st.ListSeen()) != 2 {
+		t.Errorf("ListSeen = %+v", st.ListSeen())
+	}
+	for _, p := range st.ListSeen() {
+		switch p.Hash {
+		case "a":
+			if p.Sample != "user alice logged in" || strings.Join(p.Words, " ") != "user <*> logged in" {
+				t.Errorf("a = %+v", p)
+			}
+		case "d":
+			if p.Sample != "" || p.Template != "" {
+				t.Errorf("d took over the sample of c: %+v", p)
+			}
+		}
+	}
+	if !st.IsSuppressed("a") {
+		t.Error("suppression of b not carried over to a")
+	}
+	if got := st.Resolve("b"); got != "a" {
+		t.Errorf("Resolve(b) = %q", got)
+	}
+	if got := st.Resolve("x"); got != "x" {
+		t.Errorf("Resolve(x) = %q", got)
+	}
+}
+
+func TestStoreRehash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	st := New(path)
+	st.Seen(types.LevelError, "old-a", "disk full on sda", "", nil)
+	st.Seen(types.LevelError, "old-b", "disk full on sdb", "", nil)
+	st.Seen(types.LevelError, "old-c", "cache miss", "", nil)
+	st.Seen(types.LevelError, "old-c", "cache miss", "", nil)
+	st.Seen(types.LevelWarn, "keep", "slow", "", nil)
+	st.Suppress("old-a", "noisy")
+	st.Suppress("old-c", "expected")
+	// old-a and old-b collapse into one hash; old-c gets the hash old-a had (applied at once).
+	if err := st.Rehash([]Rename{
+		{Level: types.LevelError, From: "old-a", To: "new-ab"},
+		{Level: types.LevelError, From: "old-b", To: "new-ab"},
+		{Level: types.LevelError, From: "old-c", To: "old-a"},
+	}, Scheme{Version: 2, Masks: "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+	st = New(path)
+	if err := st.Load(); err != nil {
+		t.Fatal(err)
+	}
+	for h, want := range map[string]int64{"new-ab": 2, "old-a": 2, "old-b": 0, "old-c": 0} {
+		if c := st.GetCount(types.LevelError, h); c != want {
+			t.Errorf("count of %s = %d, want %d", h, c, want)
+		}
+	}
+	for _, p := range st.ListSeen() {
+		if p.Version != 2 || p.Masks != "m1" {
+			t.Errorf("%s: scheme %d %q, want 2 m1", p.Hash, p.Version, p.Masks)
+		}
+		if p.Hash == "old-a" && p.Sample != "cache miss" {
+			t.Errorf("old-a holds %q", p.Sample)
+		}
+	}
+	if !st.IsSuppressed("new-ab") || st.IsSuppressed("old-c") {
+		t.Error("suppressions not moved to the new hashes")
+	}
+	for from, want := range map[string]string{"old-b": "new-ab", "old-c": "old-a", "old-a": "old-a"} {
+		if r := st.Resolve(from); r != want {
+			t.Errorf("Resolve(%s) = %q, want %q", from, r, want)
+		}
+	}
+}
@@ -10,6 +10,9 @@ type Record struct {
 	Message   string            `json:"message"`
 	Labels    map[string]string  `json:"labels,omitempty"`
 	SourceID  string            `json:"source_id"`
+	// Ack, when set by the source, is called once the pipeline has finished with the record
+	// (e.g. to advance a read checkpoint). Not serialized.
+	Ack func() `json:"-"`
 }
 
 // Level represents log severity (and optionally metric alert severity).